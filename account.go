@@ -0,0 +1,122 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const photosHome = "https://photos.google.com/"
+
+// activeAccountJS returns the aria-label of the Google Account button of the
+// page, which is of the form "Google Account: Name (email)".
+const activeAccountJS = `(function() {
+	const el = document.querySelector('a[aria-label^="Google Account"], a[aria-label*="@"][href*="accounts.google.com"]');
+	return el ? el.getAttribute("aria-label") : "";
+})()`
+
+var emailRx = regexp.MustCompile(`\(([^()\s]+@[^()\s]+)\)`)
+
+// activeAccount returns the email address of the account currently signed in
+// on the page, or the empty string if it could not be found.
+func activeAccount(ctx context.Context) (string, error) {
+	var label string
+	if err := chromedp.Evaluate(activeAccountJS, &label).Do(ctx); err != nil {
+		return "", err
+	}
+	m := emailRx.FindStringSubmatch(label)
+	if m == nil {
+		return "", nil
+	}
+	return m[1], nil
+}
+
+// switchAccount makes sure s.gmail is the active account, going through the
+// account chooser if it is not. On success, s.photosURL is updated to the
+// account specific home page (e.g. https://photos.google.com/u/1/).
+func (s *Session) switchAccount(ctx context.Context) error {
+	// the account button is rendered a bit after the page is loaded.
+	var active string
+	var b backoff
+	for deadline := time.Now().Add(10 * tick); ; b.sleep() {
+		var err error
+		active, err = activeAccount(ctx)
+		if err != nil {
+			return err
+		}
+		if active != "" || time.Now().After(deadline) {
+			break
+		}
+	}
+	if strings.EqualFold(active, s.gmail) {
+		return nil
+	}
+	log.Printf("Active account is %q, switching to %v", active, s.gmail)
+
+	chooser := "https://accounts.google.com/AccountChooser?" + url.Values{
+		"Email":    {s.gmail},
+		"continue": {photosHome},
+	}.Encode()
+	if err := chromedp.Navigate(chooser).Do(ctx); err != nil {
+		return err
+	}
+	timeout := time.Now().Add(2 * time.Minute)
+	var location string
+	for {
+		if time.Now().After(timeout) {
+			return exitErrorf(exitAuth, "timeout switching to account %v, at %v", s.gmail, location)
+		}
+		if err := chromedp.Location(&location).Do(ctx); err != nil {
+			return err
+		}
+		if strings.HasPrefix(location, photosHome) {
+			break
+		}
+		if *verboseFlag {
+			log.Printf("Waiting for account switch, at: %v", location)
+		}
+		time.Sleep(time.Second)
+	}
+	if err := chromedp.WaitReady("body", chromedp.ByQuery).Do(ctx); err != nil {
+		return err
+	}
+	for i := 0; i < 10; i++ {
+		var err error
+		active, err = activeAccount(ctx)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(active, s.gmail) {
+			s.photosURL = location
+			if u, err := url.Parse(location); err == nil {
+				u.RawQuery, u.Fragment = "", ""
+				s.photosURL = u.String()
+			}
+			log.Printf("Switched to account %v, at %v", s.gmail, s.photosURL)
+			return nil
+		}
+		time.Sleep(tick)
+	}
+	return exitErrorf(exitAuth, "could not switch to account %v, active account is %q", s.gmail, active)
+}
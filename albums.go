@@ -0,0 +1,179 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+)
+
+// albumsFile is the name of the file, in the download directory, where
+// -album-index records the albums, and the IDs of their items.
+const albumsFile = "albums.json"
+
+// album is an album, as recorded in the albums file.
+type album struct {
+	ID    string   `json:"id"`
+	Title string   `json:"title"`
+	URL   string   `json:"url"`
+	Items []string `json:"items"`
+}
+
+// albumLinksJS returns the links to the albums currently on the albums page,
+// with their titles.
+const albumLinksJS = `(function() {
+	const albums = [];
+	for (const a of document.querySelectorAll('a[href*="/album/"]')) {
+		if (a.href.includes("/photo/")) {
+			continue;
+		}
+		const title = (a.getAttribute("aria-label") || a.innerText || "").trim().split("\n")[0];
+		albums.push({href: a.href, label: title});
+	}
+	return albums;
+})()`
+
+// scrollCollect evaluates js, which returns links, on the current page, while
+// scrolling it down until its end, and returns all the distinct links found,
+// in order. The lists of the page are virtualized, so the links have to be
+// collected as the page scrolls.
+func scrollCollect(ctx context.Context, js string) ([]gridItem, error) {
+	var all []gridItem
+	seen := make(map[string]bool)
+	var previousScr, scr []byte
+	for {
+		var items []gridItem
+		if err := chromedp.Evaluate(js, &items).Do(ctx); err != nil {
+			return nil, err
+		}
+		for _, it := range items {
+			if !seen[it.Href] {
+				seen[it.Href] = true
+				all = append(all, it)
+			}
+		}
+		keyEvent(ctx, kb.PageDown, focusKept)
+		time.Sleep(tick)
+		if err := chromedp.CaptureScreenshot(&scr).Do(ctx); err != nil {
+			return nil, err
+		}
+		if previousScr != nil && bytes.Equal(previousScr, scr) {
+			return all, nil
+		}
+		previousScr = scr
+	}
+}
+
+// navigate navigates to url, and closes the dialogs it shows.
+func navigate(ctx context.Context, url string) error {
+	start := time.Now()
+	if err := chromedp.Navigate(url).Do(ctx); err != nil {
+		return err
+	}
+	if err := chromedp.WaitReady("body", chromedp.ByQuery).Do(ctx); err != nil {
+		return err
+	}
+	recordLatency(time.Since(start))
+	return dismissDialogs(ctx)
+}
+
+// albumIndex lists all the albums, and the IDs of their items, and writes them
+// in the albums file. It downloads nothing, so the albums of a library already
+// downloaded can be reconstructed locally.
+func (s *Session) albumIndex(ctx context.Context) error {
+	if !s.listening {
+		s.listen(ctx)
+	}
+	if err := s.login(ctx); err != nil {
+		return err
+	}
+	return chromedp.Run(ctx, chromedp.ActionFunc(s.indexAlbums))
+}
+
+// indexAlbums does the work of albumIndex, once logged in.
+func (s *Session) indexAlbums(ctx context.Context) error {
+	if err := navigate(ctx, strings.TrimSuffix(s.photosURL, "/")+"/albums"); err != nil {
+		return err
+	}
+	links, err := scrollCollect(ctx, albumLinksJS)
+	if err != nil {
+		return err
+	}
+	albums := []album{}
+	for _, l := range links {
+		parts := strings.Split(strings.TrimSuffix(l.Href, "/"), "/")
+		a := album{
+			ID:    parts[len(parts)-1],
+			Title: l.Label,
+			URL:   l.Href,
+			Items: []string{},
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := navigate(ctx, l.Href); err != nil {
+			return err
+		}
+		items, err := scrollCollect(ctx, gridItemsJS)
+		if err != nil {
+			return err
+		}
+		for _, it := range items {
+			id, err := itemID(it.Href)
+			if err != nil {
+				continue
+			}
+			a.Items = append(a.Items, id)
+		}
+		if *verboseFlag {
+			log.Printf("Album %q: %d items", a.Title, len(a.Items))
+		}
+		albums = append(albums, a)
+	}
+	log.Printf("Found %d albums", len(albums))
+
+	data, err := json.MarshalIndent(albums, "", "\t")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dlDir, albumsFile)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// albumIndexOnce runs albumIndex for s, and returns the process exit code
+// matching its outcome.
+func (s *Session) albumIndexOnce() int {
+	if err := s.albumIndex(s.ctx); err != nil {
+		log.Print(err)
+		return exitCode(err)
+	}
+	return 0
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// dashboardURL is the Google Account dashboard, which reports, among others,
+// how many photos and videos are in Google Photos.
+const dashboardURL = "https://myaccount.google.com/dashboard"
+
+// maxAuditIDs is how many of the missing item IDs the audit prints, for each
+// kind of discrepancy.
+const maxAuditIDs = 10
+
+// dashboardCountsJS returns the lines of text of the dashboard that follow the
+// Google Photos heading, where the counts of photos and videos are.
+const dashboardCountsJS = `(function() {
+	const lines = document.body.innerText.split("\n").map(l => l.trim()).filter(l => l);
+	const i = lines.findIndex(l => /^(Google )?Photos$/i.test(l));
+	if (i < 0) {
+		return [];
+	}
+	return lines.slice(i + 1, i + 16);
+})()`
+
+// googleCounts returns the numbers of photos and videos that Google reports
+// for the account. ok is false if they could not be found.
+func googleCounts(ctx context.Context) (photos, videos int, ok bool, err error) {
+	var lines []string
+	if err := chromedp.Run(ctx,
+		chromedp.Navigate(dashboardURL),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+	); err != nil {
+		return 0, 0, false, err
+	}
+	// the dashboard fills itself asynchronously.
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(tick) {
+		if err := chromedp.Run(ctx, chromedp.Evaluate(dashboardCountsJS, &lines)); err != nil {
+			return 0, 0, false, err
+		}
+		var foundPhotos, foundVideos bool
+		photos, foundPhotos = countIn(lines, "photo")
+		videos, foundVideos = countIn(lines, "video")
+		if foundPhotos || foundVideos {
+			return photos, videos, true, nil
+		}
+	}
+	return 0, 0, false, nil
+}
+
+// countIn looks in lines for a count of things (e.g. "12,345 photos"), and
+// returns it.
+func countIn(lines []string, thing string) (int, bool) {
+	for _, l := range lines {
+		fields := strings.Fields(strings.ToLower(l))
+		for i := 1; i < len(fields); i++ {
+			if fields[i] != thing && fields[i] != thing+"s" {
+				continue
+			}
+			n, err := strconv.Atoi(strings.NewReplacer(",", "", ".", "", "\u00a0", "").Replace(fields[i-1]))
+			if err == nil {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// localItems returns the IDs of the items that have a directory in dlDir.
+func localItems(dlDir string) (map[string]bool, error) {
+	entries, err := ioutil.ReadDir(dlDir)
+	if err != nil {
+		return nil, err
+	}
+	items := make(map[string]bool)
+	for _, v := range entries {
+		if !v.IsDir() || reservedDir(v.Name()) {
+			continue
+		}
+		items[v.Name()] = true
+	}
+	return items, nil
+}
+
+// audit compares the number of items Google reports for the account, the
+// items in the ledger, and the items on disk, and prints the discrepancies. It
+// returns an exitPartial error if there are any.
+func (s *Session) audit(ctx context.Context) error {
+	if !s.listening {
+		s.listen(ctx)
+	}
+	if err := s.login(ctx); err != nil {
+		return err
+	}
+	photos, videos, ok, err := googleCounts(ctx)
+	if err != nil {
+		return err
+	}
+	entries, err := loadLedger(s.stateDir)
+	if err != nil {
+		return err
+	}
+	onDisk, err := localItems(s.dlDir)
+	if err != nil {
+		return err
+	}
+
+	inLedger := make(map[string]bool)
+	var incomplete []string
+	for _, e := range entries {
+		inLedger[e.ID] = true
+		for _, f := range e.Files {
+			if _, err := os.Stat(filepath.Join(s.dlDir, filepath.FromSlash(f))); err != nil {
+				incomplete = append(incomplete, e.ID)
+				break
+			}
+		}
+	}
+	var notInLedger []string
+	for id := range onDisk {
+		if !inLedger[id] {
+			notInLedger = append(notInLedger, id)
+		}
+	}
+	sort.Strings(notInLedger)
+
+	discrepancies := len(incomplete) + len(notInLedger)
+	if s.account != "" {
+		fmt.Printf("Account %v:\n", s.account)
+	}
+	if ok {
+		fmt.Printf("Google reports:  %d items (%d photos, %d videos)\n", photos+videos, photos, videos)
+	} else {
+		log.Printf("Could not find the item counts on %v", dashboardURL)
+		fmt.Printf("Google reports:  unknown\n")
+	}
+	fmt.Printf("In the ledger:   %d items\n", len(inLedger))
+	fmt.Printf("On disk:         %d items\n", len(onDisk))
+	if ok && photos+videos != len(inLedger) {
+		discrepancies++
+		fmt.Printf("%d items difference between Google and the ledger\n", photos+videos-len(inLedger))
+	}
+	printIDs("In the ledger, but with missing files", incomplete)
+	printIDs("On disk, but not in the ledger", notInLedger)
+	if discrepancies > 0 {
+		return exitErrorf(exitPartial, "audit of %v found discrepancies", s.dlDir)
+	}
+	return nil
+}
+
+// printIDs prints title, and up to maxAuditIDs of ids.
+func printIDs(title string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	fmt.Printf("%s: %d items\n", title, len(ids))
+	for i, id := range ids {
+		if i == maxAuditIDs {
+			fmt.Printf("\t...\n")
+			break
+		}
+		fmt.Printf("\t%v\n", id)
+	}
+}
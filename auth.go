@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// authCommand is the subcommand that logs in interactively, from another
+// machine, so the profile can then be used for headless runs.
+const authCommand = "auth"
+
+// auth starts the browser with the remote debugging port -authport exposed,
+// opens Google Photos, and waits for the user to log in through the DevTools
+// of their own browser. The profile then keeps the session for the next runs.
+func (s *Session) auth(ctx context.Context) error {
+	if err := chromedp.Run(ctx, chromedp.Navigate(photosHome)); err != nil {
+		return err
+	}
+	port := *authPortFlag
+	fmt.Printf(`To log in to Google Photos from another machine:
+1. Forward the remote debugging port of this machine to yours, e.g. with
+	ssh -L %[1]d:localhost:%[1]d this-machine
+2. In Chrome, open chrome://inspect, add localhost:%[1]d with "Configure...",
+   and click "inspect" below the Google Photos page.
+3. Log in, in the page shown by DevTools.
+Waiting for up to %[2]v`+"\n", port, *authTimeoutFlag)
+
+	deadline := time.Now().Add(*authTimeoutFlag)
+	for time.Now().Before(deadline) {
+		var location string
+		if err := chromedp.Run(ctx, chromedp.Location(&location)); err != nil {
+			return err
+		}
+		if strings.HasPrefix(location, photosHome) {
+			if s.account != "" {
+				log.Printf("Logged in as %v", s.account)
+			}
+			fmt.Printf("Logged in. The profile in %v can now be used with -headless.\n", s.profileDir)
+			// let the browser persist its cookies before we close it.
+			time.Sleep(2 * time.Second)
+			return nil
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return exitErrorf(exitAuth, "not logged in after %v", *authTimeoutFlag)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// batchManifest is the name of the file, in dlDir, listing the files of the
+// batch given to the -batchrun command.
+const batchManifest = ".batch"
+
+// batchData is what the arguments of the -batchrun command are executed
+// against, as templates.
+type batchData struct {
+	// Manifest is the path of the file listing the files of the batch, one
+	// per line.
+	Manifest string
+	// Count is the number of files in the batch.
+	Count int
+}
+
+// parseBatchCommand parses the -batchrun flag, if set.
+func parseBatchCommand() ([]*template.Template, error) {
+	if *batchRunFlag == "" {
+		return nil, nil
+	}
+	return parseCommand(*batchRunFlag, "{{.Manifest}}")
+}
+
+// addToBatch adds the files at paths to the -batchrun batch, and runs the
+// command on it once it has -batchsize files.
+func (s *Session) addToBatch(paths []string) error {
+	if s.batchRun == nil {
+		return nil
+	}
+	s.batch = append(s.batch, paths...)
+	if len(s.batch) < *batchSizeFlag {
+		return nil
+	}
+	return s.flushBatch()
+}
+
+// flushBatch runs the -batchrun command on the files of the batch, if any,
+// and starts a new batch.
+func (s *Session) flushBatch() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+	manifest := filepath.Join(s.dlDir, batchManifest)
+	if err := ioutil.WriteFile(manifest, []byte(strings.Join(s.batch, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	log.Printf("Running %v on a batch of %d files", *batchRunFlag, len(s.batch))
+	if err := runCommand(s.batchRun, batchData{Manifest: manifest, Count: len(s.batch)}); err != nil {
+		return fmt.Errorf("command %q on batch %v: %w", *batchRunFlag, manifest, err)
+	}
+	s.batch = nil
+	return nil
+}
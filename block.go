@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// blockedHosts are the domains, with their subdomains, of the analytics, ads,
+// and experiments requests that -block fails.
+var blockedHosts = []string{
+	"google-analytics.com",
+	"googletagmanager.com",
+	"googlesyndication.com",
+	"googleadservices.com",
+	"doubleclick.net",
+	"csp.withgoogle.com",
+}
+
+// thumbnailSizeRx matches the size parameters of the image URLs on
+// googleusercontent.com, e.g. =w256-h171-no.
+var thumbnailSizeRx = regexp.MustCompile(`=w(\d+)-h(\d+)`)
+
+// maxThumbnailSize is the largest width and height of the images that
+// -block-thumbnails fails. The viewer shows the items larger than that.
+const maxThumbnailSize = 512
+
+// blocking reports whether requests are intercepted to be blocked.
+func blocking() bool {
+	return *blockFlag || *blockThumbnailsFlag
+}
+
+// blockedRequest reports whether the request for rawURL, of the given
+// resource type, should be failed: with -block, if it is for analytics, ads,
+// or experiments, or for logging, and with -block-thumbnails, if it is for a
+// thumbnail of the grid.
+func blockedRequest(rawURL string, typ network.ResourceType) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if *blockFlag {
+		for _, h := range blockedHosts {
+			if host == h || strings.HasSuffix(host, "."+h) {
+				return true
+			}
+		}
+		if strings.HasSuffix(u.Path, "/gen_204") || host == "play.google.com" && strings.HasPrefix(u.Path, "/log") {
+			return true
+		}
+	}
+	if *blockThumbnailsFlag && typ == network.ResourceTypeImage && strings.HasSuffix(host, ".googleusercontent.com") {
+		m := thumbnailSizeRx.FindStringSubmatch(u.Path)
+		if m == nil {
+			return false
+		}
+		w, _ := strconv.Atoi(m[1])
+		h, _ := strconv.Atoi(m[2])
+		return w <= maxThumbnailSize && h <= maxThumbnailSize
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestBlockedRequest(t *testing.T) {
+	defer func(block, thumbnails bool) {
+		*blockFlag, *blockThumbnailsFlag = block, thumbnails
+	}(*blockFlag, *blockThumbnailsFlag)
+	tests := []struct {
+		url        string
+		typ        network.ResourceType
+		block      bool
+		thumbnails bool
+		want       bool
+	}{
+		{url: "https://www.google-analytics.com/collect", typ: network.ResourceTypeScript, block: true, want: true},
+		{url: "https://stats.g.doubleclick.net/j/collect", typ: network.ResourceTypeXHR, block: true, want: true},
+		{url: "https://www.google-analytics.com/collect", typ: network.ResourceTypeScript, want: false},
+		{url: "https://photos.google.com/gen_204?x=1", typ: network.ResourceTypePing, block: true, want: true},
+		{url: "https://play.google.com/log?format=json", typ: network.ResourceTypeXHR, block: true, want: true},
+		{url: "https://play.google.com/store", typ: network.ResourceTypeDocument, block: true, want: false},
+		{url: "https://photos.google.com/photo/AF1Qip", typ: network.ResourceTypeDocument, block: true, thumbnails: true, want: false},
+		{url: "https://notdoubleclick.net/x", typ: network.ResourceTypeScript, block: true, want: false},
+		{url: "https://lh3.googleusercontent.com/abc=w256-h171-no", typ: network.ResourceTypeImage, thumbnails: true, want: true},
+		{url: "https://lh3.googleusercontent.com/abc=w256-h171-no", typ: network.ResourceTypeImage, block: true, want: false},
+		{url: "https://lh3.googleusercontent.com/abc=w2048-h1536-no", typ: network.ResourceTypeImage, thumbnails: true, want: false},
+		{url: "https://lh3.googleusercontent.com/abc=w256-h171-no", typ: network.ResourceTypeXHR, thumbnails: true, want: false},
+		{url: "https://lh3.googleusercontent.com/abc", typ: network.ResourceTypeImage, thumbnails: true, want: false},
+		{url: "://bad", typ: network.ResourceTypeImage, block: true, thumbnails: true, want: false},
+	}
+	for _, tt := range tests {
+		*blockFlag, *blockThumbnailsFlag = tt.block, tt.thumbnails
+		if got := blockedRequest(tt.url, tt.typ); got != tt.want {
+			t.Errorf("blockedRequest(%q, %v) with -block=%v -block-thumbnails=%v = %v, want %v", tt.url, tt.typ, tt.block, tt.thumbnails, got, tt.want)
+		}
+	}
+}
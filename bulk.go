@@ -0,0 +1,283 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+)
+
+// selectItemsJS selects, in the timeline grid, the items with the given
+// links, by clicking their check boxes, and returns how many it selected.
+const selectItemsJS = `(function(hrefs) {
+	let n = 0;
+	for (const href of hrefs) {
+		const a = Array.from(document.querySelectorAll('a[href*="/photo/"]')).find(a => a.href === href);
+		if (!a) {
+			continue;
+		}
+		let box = null;
+		for (let el = a.parentElement; el !== null && box === null; el = el.parentElement) {
+			box = el.querySelector('[role="checkbox"]');
+		}
+		if (box === null) {
+			continue;
+		}
+		if (box.getAttribute("aria-checked") !== "true") {
+			box.click();
+		}
+		n++;
+	}
+	return n;
+})`
+
+// bulkDownload, with -bulk, downloads the items from the timeline grid, by
+// batches of *bulkFlag items: it selects them, downloads them all at once as a
+// zip archive, and unpacks it into the items directories. It goes from the
+// most recent item to the oldest, relying on the ledger to skip the items
+// already downloaded.
+func (s *Session) bulkDownload(ctx context.Context) error {
+	done := make(map[string]bool)
+	n := 0
+	var previousScr, scr []byte
+	for {
+		if err := s.waitIfPaused(ctx); err != nil {
+			return err
+		}
+		if err := dismissDialogs(ctx); err != nil {
+			return err
+		}
+		var items []gridItem
+		if err := chromedp.Evaluate(gridItemsJS, &items).Do(ctx); err != nil {
+			return err
+		}
+		var batch []string
+		for _, it := range items {
+			if done[it.Href] {
+				continue
+			}
+			done[it.Href] = true
+			switch {
+			case s.skipped(it.Href):
+				log.Printf("Skipping %v, as it is in the skip list", it.Href)
+				s.summary.addSkip(it.Href)
+				s.printItem(it.Href, actionSkipped, nil, 0, nil)
+			case s.inLedger(it.Href):
+				s.printItem(it.Href, actionKnown, nil, 0, nil)
+			default:
+				batch = append(batch, it.Href)
+			}
+		}
+		for len(batch) > 0 {
+			size := *bulkFlag
+			if *nItemsFlag > 0 && *nItemsFlag-n < size {
+				size = *nItemsFlag - n
+			}
+			if size > len(batch) {
+				size = len(batch)
+			}
+			if err := s.checkFreeSpace(0); err != nil {
+				return err
+			}
+			if err := s.applyRateLimit(ctx); err != nil {
+				return err
+			}
+			if err := s.coolDownIfThrottled(ctx); err != nil {
+				return err
+			}
+			if err := s.downloadBulk(ctx, batch[:size]); err != nil {
+				return err
+			}
+			n += size
+			if *nItemsFlag > 0 && n >= *nItemsFlag || s.timeUp() {
+				return nil
+			}
+			batch = batch[size:]
+		}
+
+		keyEvent(ctx, kb.PageDown, focusKept)
+		time.Sleep(tick)
+		if err := chromedp.CaptureScreenshot(&scr).Do(ctx); err != nil {
+			return err
+		}
+		if previousScr != nil && bytes.Equal(previousScr, scr) {
+			// reached the end of the timeline.
+			return nil
+		}
+		previousScr = scr
+	}
+}
+
+// downloadBulk selects the items of the grid with the given links, downloads
+// them together, and stores each of them as if downloaded on its own. The
+// files of the archive are mapped back to the items by their order in the
+// archive, which is the order of the grid. When the archive does not have
+// exactly one file per item, the items are downloaded one by one instead.
+func (s *Session) downloadBulk(ctx context.Context, hrefs []string) error {
+	s.beat(hrefs[0], "bulk")
+	for _, href := range hrefs {
+		s.startItem(href)
+	}
+	args, err := json.Marshal(hrefs)
+	if err != nil {
+		return err
+	}
+	var selected int
+	if err := chromedp.Evaluate(fmt.Sprintf("%s(%s)", selectItemsJS, args), &selected).Do(ctx); err != nil {
+		return err
+	}
+	// whatever happens, the selection must not leak into the next batch.
+	defer keyEvent(ctx, kb.Escape, focusAny)
+	if selected != len(hrefs) {
+		log.Printf("Could only select %d of %d items, downloading them one by one", selected, len(hrefs))
+		return s.downloadEach(ctx, hrefs)
+	}
+	if *verboseFlag {
+		log.Printf("Downloading %d items at once, from %v", len(hrefs), hrefs[0])
+	}
+	dl, err := s.downloadChecked(ctx, hrefs[0], startDownload, nil)
+	if err != nil {
+		return err
+	}
+	archive := dl.path()
+	defer os.Remove(archive)
+	if !strings.EqualFold(filepath.Ext(dl.filename), ".zip") {
+		if len(hrefs) != 1 {
+			log.Printf("Got %v instead of an archive, downloading the items one by one", dl.filename)
+			return s.downloadEach(ctx, hrefs)
+		}
+		path, err := s.moveDownload(ctx, dl, hrefs[0])
+		if err != nil {
+			return err
+		}
+		return s.storeBulkItem(hrefs[0], []string{path}, dl.received)
+	}
+
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	var files []*zip.File
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			files = append(files, f)
+		}
+	}
+	if len(files) != len(hrefs) {
+		log.Printf("The archive has %d files for %d items, downloading them one by one", len(files), len(hrefs))
+		return s.downloadEach(ctx, hrefs)
+	}
+	for i, f := range files {
+		id, err := itemID(hrefs[i])
+		if err != nil {
+			return err
+		}
+		dir := filepath.Join(s.dlDir, id)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		path := filepath.Join(dir, filepath.Base(filepath.FromSlash(f.Name)))
+		if err := unzipFile(f, path); err != nil {
+			return err
+		}
+		if err := s.storeBulkItem(hrefs[i], []string{path}, int64(f.UncompressedSize64)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeBulkItem records the item at location, downloaded in bulk as the files
+// at paths, in the ledger and the summary, and post-processes it.
+func (s *Session) storeBulkItem(location string, paths []string, size int64) error {
+	id, err := itemID(location)
+	if err != nil {
+		return err
+	}
+	// the grid does not tell the kinds of the items apart, their files do.
+	entry := ledgerEntry{ID: id, URL: location, Kind: fileKind(paths[0]), Size: size, Time: time.Now()}
+	if err := setItemXattrs(paths, id, location, nil); err != nil {
+		return err
+	}
+	paths, err = s.dedupe(paths)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		rel, err := filepath.Rel(s.dlDir, path)
+		if err != nil {
+			return err
+		}
+		entry.Files = append(entry.Files, filepath.ToSlash(rel))
+	}
+	if err := s.appendLedger(entry); err != nil {
+		return err
+	}
+	s.summary.addDownload(id, size)
+	s.printItem(location, actionDownloaded, paths, size, nil)
+	return s.postProcess(paths, location, nil)
+}
+
+// downloadEach downloads the items at hrefs one by one, each in a new tab, for
+// when the bulk download did not work out.
+func (s *Session) downloadEach(ctx context.Context, hrefs []string) error {
+	for _, href := range hrefs {
+		if err := s.retryItem(ctx, href); err != nil {
+			if !isItemError(err) {
+				return err
+			}
+			if err := s.quarantine(href, err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unzipFile extracts f to path.
+func unzipFile(f *zip.File, path string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(path, f.Modified, f.Modified)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpointFile is the name of the file, in the state directory, where we
+// record, every -checkpoint items, where the crawl is, so that it can be
+// resumed there with -resume-checkpoint.
+const checkpointFile = ".checkpoint"
+
+// checkpoint is the state of the crawl, as recorded in checkpointFile.
+type checkpoint struct {
+	Time time.Time `json:"time"`
+	// Location is the item being processed, and LastDone the last done
+	// item.
+	Location string `json:"location"`
+	LastDone string `json:"lastDone,omitempty"`
+	// Direction is the -direction of the crawl.
+	Direction string `json:"direction"`
+	// Pending are the items whose downloads were in flight, with -pipeline.
+	Pending []string `json:"pending,omitempty"`
+}
+
+// maybeCheckpoint writes checkpointFile, with location as the item being
+// processed, if *checkpointFlag items were processed since it was last
+// written.
+func (s *Session) maybeCheckpoint(location string) error {
+	if *checkpointFlag <= 0 {
+		return nil
+	}
+	s.sinceCheckpoint++
+	if s.sinceCheckpoint < *checkpointFlag {
+		return nil
+	}
+	s.sinceCheckpoint = 0
+	cp := checkpoint{
+		Time:      time.Now(),
+		Location:  location,
+		LastDone:  s.lastDone,
+		Direction: *directionFlag,
+	}
+	for _, p := range s.pending {
+		cp.Pending = append(cp.Pending, p.location)
+	}
+	data, err := json.MarshalIndent(cp, "", "\t")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.stateDir, checkpointFile)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// resumeCheckpoint, with -resume-checkpoint, loads checkpointFile, if any,
+// so that the crawl resumes at its item, rather than after the last done
+// one. Its pending items go to the failed queue. The run summary only
+// counts the items of this run, as for any other.
+func (s *Session) resumeCheckpoint() error {
+	s.resumeFrom = ""
+	if !*resumeFlag {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.stateDir, checkpointFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return err
+	}
+	if cp.Direction != *directionFlag {
+		log.Printf("Not resuming from the checkpoint of %v, made with -direction %v", cp.Time.Format(time.RFC3339), cp.Direction)
+		return nil
+	}
+	log.Printf("Resuming from the checkpoint of %v, at %v", cp.Time.Format(time.RFC3339), cp.Location)
+	s.resumeFrom = cp.Location
+	for _, location := range cp.Pending {
+		if s.inLedger(location) {
+			continue
+		}
+		if err := s.quarantine(location, errInterrupted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeCheckpoint removes checkpointFile, once the crawl is complete.
+func (s *Session) removeCheckpoint() error {
+	err := os.Remove(filepath.Join(s.stateDir, checkpointFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
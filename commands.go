@@ -0,0 +1,204 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// command is a subcommand of gphotos-cdp, e.g. "gphotos-cdp sync -dldir dir".
+// Each command only accepts its own flags. The legacy form, with flags only,
+// is still the sync command, with the mode flags (-enumerate, -album-index,
+// -audit, ...) selecting the other commands.
+type command struct {
+	name string
+	// args is the synopsis of the arguments of the command, after the
+	// flags, and summary its one-line description.
+	args    string
+	summary string
+	// flags are the names of the flags of the command. If nil, the command
+	// has all the flags, except the ones in exclude.
+	flags   []string
+	exclude []string
+	// mode, if not empty, is the name of the flag that the command sets,
+	// i.e. that selects it in the legacy form.
+	mode string
+}
+
+// Commands other than the ones of authCommand, stateCommand, and
+// replayCommand.
+const (
+	syncCommand   = "sync"
+	listCommand   = "list"
+	albumsCommand = "albums"
+	verifyCommand = "verify"
+	auditCommand  = "audit"
+	helpCommand   = "help"
+)
+
+// browserFlags are the flags of all the commands that run the browser.
+var browserFlags = []string{
+	"config", "dev", "state", "dldir", "v", "loglevel", "headless", "chrome-binary", "proxy",
+	"accounts", "gmail", "profile", "record", "listen", "trace", "display-setup", "vnc-port",
+	"devtools", "lowmem", "block", "block-thumbnails", "stalltimeout", "strict",
+}
+
+var commands = []*command{
+	{
+		name:    syncCommand,
+		summary: "download the items of the library that are not downloaded yet (the default)",
+		exclude: []string{"enumerate", "album-index", "audit", "takeout", "authport", "authtimeout"},
+	},
+	{
+		name:    listCommand,
+		summary: "list all the items of the library in " + itemsFile + ", as -enumerate",
+		flags:   browserFlags,
+		mode:    "enumerate",
+	},
+	{
+		name:    albumsCommand,
+		summary: "list all the albums and their items in " + albumsFile + ", as -album-index",
+		flags:   browserFlags,
+		mode:    "album-index",
+	},
+	{
+		name:    verifyCommand,
+		summary: "check the files of the items of the ledger, and with -takeout, compare them with a Takeout archive",
+		flags:   []string{"config", "state", "dldir", "accounts", "v", "loglevel", "validate", "takeout"},
+	},
+	{
+		name:    auditCommand,
+		summary: "compare the number of items Google reports with the ledger and the files, as -audit",
+		flags:   browserFlags,
+		mode:    "audit",
+	},
+	{
+		name:    authCommand,
+		summary: "log in from the browser of another machine, through the remote debugging",
+		flags: []string{"config", "dev", "v", "loglevel", "headless", "chrome-binary", "proxy", "accounts", "gmail",
+			"profile", "display-setup", "vnc-port", "authport", "authtimeout"},
+	},
+	{
+		name:    stateCommand,
+		args:    "export|import|merge|rebuild args...",
+		summary: "export, import, merge, or rebuild the state, see \"state help\"",
+		flags:   []string{"config", "state", "dldir", "v", "loglevel"},
+	},
+	{
+		name:    replayCommand,
+		args:    "dir",
+		summary: "replay a session recorded with -record",
+		flags:   []string{},
+	},
+}
+
+// has reports whether the flag name is one of the flags of c.
+func (c *command) has(name string) bool {
+	names := c.flags
+	if names == nil {
+		names = c.exclude
+	}
+	for _, v := range names {
+		if v == name {
+			return c.flags != nil
+		}
+	}
+	return c.flags == nil
+}
+
+// flagSet returns the flag set of c, whose flags are the global ones.
+func (c *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0]+" "+c.name, flag.ExitOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		if c.has(f.Name) {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %v %v [flags] %v\n%v\n", os.Args[0], c.name, c.args, c.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// usage prints the list of the commands.
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %v [command] [flags]\ncommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(flag.CommandLine.Output(), "\t%-8v %v\n", c.name, c.summary)
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "Run \"%v help command\" for the flags of a command. Without a command, all the flags are accepted:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// parseSubcommand parses the command line args, without the program name, and
+// returns the command, and its arguments after the flags. For the state
+// command, the first of them is the action, which comes before the flags.
+func parseSubcommand(args []string) (*command, []string) {
+	flag.Usage = usage
+	if len(args) > 0 && args[0] == helpCommand {
+		if len(args) > 1 {
+			if c := lookupCommand(args[1]); c != nil {
+				c.flagSet().Usage()
+				os.Exit(0)
+			}
+		}
+		usage()
+		os.Exit(0)
+	}
+	var c *command
+	if len(args) > 0 {
+		c = lookupCommand(args[0])
+	}
+	if c == nil {
+		// the legacy form.
+		flag.Parse()
+		return lookupCommand(syncCommand), flag.Args()
+	}
+	var action []string
+	args = args[1:]
+	if c.name == stateCommand && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[:1], args[1:]
+	}
+	// the global flag set does the parsing, so the repeatable flags are set
+	// only once, and loadConfig knows which flags are set.
+	flag.Usage = c.flagSet().Usage
+	flag.CommandLine.Parse(args)
+	flag.Visit(func(f *flag.Flag) {
+		if !c.has(f.Name) {
+			fmt.Fprintf(flag.CommandLine.Output(), "flag provided but not defined: -%v\n", f.Name)
+			flag.Usage()
+			os.Exit(2)
+		}
+	})
+	if c.mode != "" {
+		flag.CommandLine.Set(c.mode, "true")
+	}
+	return c, append(action, flag.Args()...)
+}
+
+func lookupCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withCommandLine runs f with a fresh flag.CommandLine, with the same flags,
+// i.e. with none of them set yet, and restores the flags f sets to their
+// defaults afterwards.
+func withCommandLine(t *testing.T, f func()) {
+	saved, savedUsage := flag.CommandLine, flag.Usage
+	fs := flag.NewFlagSet(saved.Name(), flag.ContinueOnError)
+	saved.VisitAll(func(fl *flag.Flag) {
+		if !strings.HasPrefix(fl.Name, "test.") {
+			fs.Var(fl.Value, fl.Name, fl.Usage)
+		}
+	})
+	flag.CommandLine = fs
+	defer func() {
+		fs.Visit(func(fl *flag.Flag) {
+			if err := fl.Value.Set(fl.DefValue); err != nil {
+				t.Fatal(err)
+			}
+		})
+		flag.CommandLine, flag.Usage = saved, savedUsage
+	}()
+	f()
+}
+
+func TestParseSubcommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantCmd  string
+		wantArgs []string
+		wantSet  map[string]string
+	}{
+		{
+			args:    []string{"sync", "-dldir", "/photos", "-n", "10"},
+			wantCmd: syncCommand,
+			wantSet: map[string]string{"dldir": "/photos", "n": "10"},
+		},
+		{
+			args:    []string{"list", "-dldir", "/photos"},
+			wantCmd: listCommand,
+			wantSet: map[string]string{"dldir": "/photos", "enumerate": "true"},
+		},
+		{
+			args:     []string{"state", "export", "-dldir", "/photos", "state.tar.gz"},
+			wantCmd:  stateCommand,
+			wantArgs: []string{"export", "state.tar.gz"},
+			wantSet:  map[string]string{"dldir": "/photos"},
+		},
+		{
+			args:     []string{"replay", "/tmp/record"},
+			wantCmd:  replayCommand,
+			wantArgs: []string{"/tmp/record"},
+			wantSet:  map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		withCommandLine(t, func() {
+			cmd, args := parseSubcommand(tt.args)
+			if cmd.name != tt.wantCmd {
+				t.Errorf("parseSubcommand(%q) command = %v, want %v", tt.args, cmd.name, tt.wantCmd)
+			}
+			if len(args) == 0 {
+				args = nil
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("parseSubcommand(%q) args = %q, want %q", tt.args, args, tt.wantArgs)
+			}
+			set := make(map[string]string)
+			flag.Visit(func(f *flag.Flag) {
+				set[f.Name] = f.Value.String()
+			})
+			if !reflect.DeepEqual(set, tt.wantSet) {
+				t.Errorf("parseSubcommand(%q) set flags = %v, want %v", tt.args, set, tt.wantSet)
+			}
+		})
+	}
+}
+
+func TestCommandHas(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		flag string
+		want bool
+	}{
+		{syncCommand, "dldir", true},
+		{syncCommand, "enumerate", false},
+		{listCommand, "dldir", true},
+		{listCommand, "n", false},
+		{stateCommand, "headless", false},
+		{replayCommand, "dldir", false},
+	}
+	for _, tt := range tests {
+		if got := lookupCommand(tt.cmd).has(tt.flag); got != tt.want {
+			t.Errorf("%v has -%v = %v, want %v", tt.cmd, tt.flag, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configValue is the value of a flag in the config file.
+type configValue struct {
+	name, value string
+	line        int
+}
+
+// config is the content of the config file: the values of the flags, and
+// the accounts, with the values of the flags specific to each of them.
+type config struct {
+	flags        []configValue
+	accounts     []string
+	accountFlags map[string][]configValue
+	// onCommandLine are the flags set on the command line, which the
+	// config does not override. It is recorded before the config is
+	// applied, as flag.Visit then sees its flags too.
+	onCommandLine map[string]bool
+}
+
+// perAccountFlags are the flags that can be set for each account, in the
+// accounts section of the config file. They are the ones the sessions are
+// made of.
+var perAccountFlags = map[string]bool{
+	"gmail":              true,
+	"run":                true,
+	"upload":             true,
+	"skip":               true,
+	"minsize":            true,
+	"maxsize":            true,
+	"minrate":            true,
+	"minfree":            true,
+	"ratelimit":          true,
+	"ratelimit-schedule": true,
+	"proxy":              true,
+}
+
+// defaultConfigPath returns the path of the config file used without
+// -config, which may not exist.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gphotos-cdp", "config.yaml")
+}
+
+// parseConfig parses data, the config file, which is a subset of YAML: lines
+// of "flag: value", where the flags are named as on the command line, and
+// list values ([a, b]) are for the repeatable flags. An "accounts:" section
+// maps each account to its own flags, indented below it:
+//
+//	dldir: /photos
+//	headless: true
+//	accounts:
+//	  alice:
+//	    gmail: alice@example.com
+//	  bob:
+//	    upload: s3://bucket/bob
+func parseConfig(data []byte) (*config, error) {
+	c := &config{accountFlags: make(map[string][]configValue)}
+	inAccounts := false
+	account := ""
+	accountIndent := -1
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		l := stripComment(sc.Text())
+		trimmed := strings.TrimSpace(l)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		indent := len(l) - len(strings.TrimLeft(l, " \t"))
+		kv := strings.SplitN(trimmed, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d: want name: value", line)
+		}
+		name, value := strings.TrimSpace(kv[0]), unquote(strings.TrimSpace(kv[1]))
+		switch {
+		case indent == 0:
+			inAccounts, account = false, ""
+			if name == "accounts" && value == "" {
+				inAccounts = true
+				continue
+			}
+			c.flags = append(c.flags, configValue{name, value, line})
+		case !inAccounts:
+			return nil, fmt.Errorf("line %d: unexpected indentation", line)
+		case value == "" && (accountIndent < 0 || indent <= accountIndent):
+			account, accountIndent = name, indent
+			c.accounts = append(c.accounts, account)
+		case account == "":
+			return nil, fmt.Errorf("line %d: flag outside of an account", line)
+		default:
+			if !perAccountFlags[name] {
+				return nil, fmt.Errorf("line %d: -%v cannot be set per account", line, name)
+			}
+			c.accountFlags[account] = append(c.accountFlags[account], configValue{name, value, line})
+		}
+	}
+	return c, sc.Err()
+}
+
+// stripComment removes the comment at the end of the line l, if any: from a
+// " #" that is not inside quotes.
+func stripComment(l string) string {
+	var quote byte
+	for i := 0; i < len(l); i++ {
+		switch c := l[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && i > 0 && (l[i-1] == ' ' || l[i-1] == '\t'):
+			return l[:i]
+		}
+	}
+	return l
+}
+
+// unquote removes the YAML quotes around v, if any.
+func unquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' && v[len(v)-1] == '"' || v[0] == '\'' && v[len(v)-1] == '\'') {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// loadConfig reads and applies the config file at path, if it exists, or
+// fails if it does not and explicit is set. The flags already set on the
+// command line keep their values. It returns the config, for the per-account
+// flags, or nil if there is no config.
+func loadConfig(path string, explicit bool) (*config, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	onCommandLine := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+	c.onCommandLine = onCommandLine
+	for _, v := range c.flags {
+		if onCommandLine[v.name] {
+			continue
+		}
+		if err := setConfigFlag(v); err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+	}
+	if len(c.accounts) > 0 && !onCommandLine["accounts"] {
+		*accountsFlag = strings.Join(c.accounts, ",")
+	}
+	return c, nil
+}
+
+// setConfigFlag sets the flag of v, once per element if v is a list.
+func setConfigFlag(v configValue) error {
+	if flag.Lookup(v.name) == nil {
+		return fmt.Errorf("line %d: no -%v flag", v.line, v.name)
+	}
+	values := []string{v.value}
+	if strings.HasPrefix(v.value, "[") && strings.HasSuffix(v.value, "]") {
+		values = nil
+		for _, e := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(v.value, "["), "]"), ",") {
+			if e = unquote(strings.TrimSpace(e)); e != "" {
+				values = append(values, e)
+			}
+		}
+	}
+	for _, value := range values {
+		if err := flag.Set(v.name, value); err != nil {
+			return fmt.Errorf("line %d: %v", v.line, err)
+		}
+	}
+	return nil
+}
+
+// withAccountFlags runs f with the flags of account in c set, and restores
+// them afterwards. The flags of the account override those of the top level
+// of the config, but not those of the command line. c may be nil.
+func (c *config) withAccountFlags(account string, f func() error) error {
+	if c == nil {
+		return f()
+	}
+	for _, v := range c.accountFlags[account] {
+		if c.onCommandLine[v.name] {
+			continue
+		}
+		old := flag.Lookup(v.name).Value.String()
+		if err := flag.Set(v.name, v.value); err != nil {
+			return fmt.Errorf("account %v: line %d: %v", account, v.line, err)
+		}
+		defer flag.Set(v.name, old)
+	}
+	if err := checkSessionFlags(); err != nil {
+		return fmt.Errorf("account %v: %v", account, err)
+	}
+	return f()
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    *config
+		wantErr bool
+	}{
+		{
+			name: "flags",
+			in: `---
+# a comment
+dldir: /photos
+v: true # verbose
+headless: "true"
+minsize: '1MB'
+run: "pk-put #tag {{.Path}}" # tagged
+`,
+			want: &config{
+				flags: []configValue{
+					{"dldir", "/photos", 3},
+					{"v", "true", 4},
+					{"headless", "true", 5},
+					{"minsize", "1MB", 6},
+					{"run", "pk-put #tag {{.Path}}", 7},
+				},
+				accountFlags: map[string][]configValue{},
+			},
+		},
+		{
+			name: "accounts",
+			in: `dldir: /photos
+accounts:
+  alice:
+    gmail: alice@gmail.com
+    skip: /photos/alice.skip
+  bob:
+    gmail: bob@gmail.com
+headless: true
+`,
+			want: &config{
+				flags: []configValue{
+					{"dldir", "/photos", 1},
+					{"headless", "true", 8},
+				},
+				accounts: []string{"alice", "bob"},
+				accountFlags: map[string][]configValue{
+					"alice": {{"gmail", "alice@gmail.com", 4}, {"skip", "/photos/alice.skip", 5}},
+					"bob":   {{"gmail", "bob@gmail.com", 7}},
+				},
+			},
+		},
+		{name: "no value separator", in: "dldir /photos\n", wantErr: true},
+		{name: "unexpected indentation", in: "dldir: /photos\n  v: true\n", wantErr: true},
+		{name: "flag outside of an account", in: "accounts:\n    gmail: a\n", wantErr: true},
+		{name: "not a per-account flag", in: "accounts:\n  alice:\n    dldir: /photos\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseConfig([]byte(tt.in))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: parseConfig error = %v, want error: %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: parseConfig = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWithAccountFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	data := `minsize: 1MB
+ratelimit: 2MBps
+accounts:
+  alice:
+    minsize: 2MB
+    ratelimit: 3MBps
+  bob:
+    gmail: bob@gmail.com
+  carol:
+    run: echo
+`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(v string) { *accountsFlag = v }(*accountsFlag)
+	withCommandLine(t, func() {
+		if err := flag.CommandLine.Parse([]string{"-ratelimit", "1MBps", "-upload-delete"}); err != nil {
+			t.Fatal(err)
+		}
+		c, err := loadConfig(path, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			account       string
+			wantMinSize   string
+			wantRateLimit string
+			wantErr       bool
+		}{
+			// the account overrides the top level, but not the command line.
+			{account: "alice", wantMinSize: "2MB", wantRateLimit: "1MBps"},
+			{account: "bob", wantMinSize: "1MB", wantRateLimit: "1MBps"},
+			// -run with -upload-delete.
+			{account: "carol", wantErr: true},
+		}
+		for _, tt := range tests {
+			var minSize, rateLimit string
+			err := c.withAccountFlags(tt.account, func() error {
+				minSize, rateLimit = *minSizeFlag, *rateLimitFlag
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("%v: withAccountFlags error = %v, want error: %v", tt.account, err, tt.wantErr)
+				continue
+			}
+			if err != nil {
+				continue
+			}
+			if minSize != tt.wantMinSize || rateLimit != tt.wantRateLimit {
+				t.Errorf("%v: -minsize %q and -ratelimit %q, want %q and %q", tt.account, minSize, rateLimit, tt.wantMinSize, tt.wantRateLimit)
+			}
+		}
+		if *minSizeFlag != "1MB" || *runFlag != "" {
+			t.Errorf("flags of the accounts not restored: -minsize %q, -run %q", *minSizeFlag, *runFlag)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Thresholds above which we consider that Google is throttling us.
+const (
+	maxTooManyRequests     = 3 // 429 responses since the last cool-down
+	maxConsecutiveFailures = 3 // items in a row that failed to download
+)
+
+// tryAgainLaterJS reports whether the page shows a message telling us to
+// slow down.
+const tryAgainLaterJS = `(function() {
+	for (const el of document.querySelectorAll('[role="alert"], [role="status"], [aria-live]')) {
+		if (/try again later|too many requests|unusual traffic/i.test(el.innerText || "")) {
+			return true;
+		}
+	}
+	return false;
+})()`
+
+// throttled reports whether there are signs that Google is rate limiting
+// us, and which one.
+func (s *Session) throttled(ctx context.Context) (bool, string, error) {
+	if n := atomic.LoadInt32(&s.tooManyRequests); n >= maxTooManyRequests {
+		return true, "too many 429 responses", nil
+	}
+	if s.consecutiveFailures >= maxConsecutiveFailures {
+		return true, "too many failed downloads in a row", nil
+	}
+	var tryAgainLater bool
+	if err := chromedp.Evaluate(tryAgainLaterJS, &tryAgainLater).Do(ctx); err != nil {
+		return false, "", err
+	}
+	if tryAgainLater {
+		return true, `"try again later" message`, nil
+	}
+	return false, "", nil
+}
+
+// coolDownIfThrottled pauses the crawl for about *coolDownFlag, if Google
+// seems to be rate limiting us. The actual pause is randomized by up to 25%
+// either way, so we do not come back at suspiciously regular intervals.
+func (s *Session) coolDownIfThrottled(ctx context.Context) error {
+	if *coolDownFlag <= 0 {
+		return nil
+	}
+	throttled, reason, err := s.throttled(ctx)
+	if err != nil || !throttled {
+		return err
+	}
+	d := *coolDownFlag
+	jitter := time.Duration((rand.Float64() - 0.5) * 0.5 * float64(d))
+	d = (d + jitter).Round(time.Second)
+	log.Printf("Looks like we are being rate limited (%s), cooling down for %v", reason, d)
+	s.summary.CoolDowns++
+	counters.Add("coolDowns", 1)
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	atomic.StoreInt32(&s.tooManyRequests, 0)
+	s.consecutiveFailures = 0
+	log.Printf("Resuming after cool-down")
+	return nil
+}
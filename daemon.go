@@ -0,0 +1,75 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// daemon syncs all the sessions, one after the other, every *intervalFlag,
+// forever. Each session keeps its browser (and hence its authenticated
+// session) running in between. It only returns when a sync fails because
+// authentication is needed.
+func daemon(sessions []*Session) error {
+	for {
+		if runWindow != nil && !runWindow.contains(time.Now()) {
+			next := runWindow.nextStart(time.Now())
+			log.Printf("Outside of -window %v, next sync at %v", *windowFlag, next.Format(time.RFC3339))
+			sleepKeepingAlive(sessions, next)
+		}
+		start := time.Now()
+		for _, s := range sessions {
+			if s.account != "" {
+				log.Printf("Syncing account %v", s.account)
+			}
+			err := s.sync(s.ctx)
+			if err := s.writeSummary(err); err != nil {
+				log.Printf("Error writing run summary: %v", err)
+			}
+			s.notifyResult(err)
+			if err != nil {
+				if exitCode(err) == exitAuth || errors.Is(err, context.Canceled) {
+					return err
+				}
+				log.Printf("Sync failed, will retry at next interval: %v", err)
+			} else {
+				log.Printf("Sync done: %d items downloaded", s.summary.downloaded())
+			}
+		}
+
+		next := start.Add(*intervalFlag)
+		log.Printf("Next sync at %v", next.Format(time.RFC3339))
+		sleepKeepingAlive(sessions, next)
+	}
+}
+
+// sleepKeepingAlive sleeps until next, refreshing the sessions every
+// *keepAliveFlag in the meantime.
+func sleepKeepingAlive(sessions []*Session, next time.Time) {
+	for *keepAliveFlag > 0 && time.Until(next) > *keepAliveFlag {
+		time.Sleep(*keepAliveFlag)
+		for _, s := range sessions {
+			if err := s.keepAlive(s.ctx); err != nil {
+				log.Printf("Error refreshing session: %v", err)
+			}
+		}
+	}
+	time.Sleep(time.Until(next))
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// debugDir is the directory, in dlDir, where the debug bundles are written.
+const debugDir = "debug"
+
+// pageLogSize is the number of entries kept in a pageLog.
+const pageLogSize = 200
+
+// pageLog keeps the most recent console messages, exceptions, and network
+// errors of the page, so they can be included in the debug bundles. In debug
+// mode, they are also logged as they come.
+type pageLog struct {
+	mu      sync.Mutex
+	entries []string
+	// urls are the URLs of the requests in flight, by request ID.
+	urls map[network.RequestID]string
+}
+
+func (pl *pageLog) add(format string, args ...interface{}) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	msg := fmt.Sprintf(format, args...)
+	if *verboseFlag {
+		log.Printf("Page %s", msg)
+	}
+	entry := time.Now().Format("15:04:05.000 ") + msg
+	pl.entries = append(pl.entries, entry)
+	if len(pl.entries) > pageLogSize {
+		pl.entries = pl.entries[len(pl.entries)-pageLogSize:]
+	}
+}
+
+func (pl *pageLog) lines() []string {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return append([]string(nil), pl.entries...)
+}
+
+// listenPageLog registers a listener that records the console messages,
+// exceptions, and network errors of the page in s.pageLog.
+func (s *Session) listenPageLog(ctx context.Context) {
+	pl := &s.pageLog
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *runtime.EventConsoleAPICalled:
+			var args []string
+			for _, arg := range ev.Args {
+				if arg.Value != nil {
+					args = append(args, string(arg.Value))
+				} else {
+					args = append(args, arg.Description)
+				}
+			}
+			pl.add("console.%s: %s", ev.Type, strings.Join(args, " "))
+		case *runtime.EventExceptionThrown:
+			details := ev.ExceptionDetails
+			msg := details.Text
+			if details.Exception != nil && details.Exception.Description != "" {
+				msg += " " + details.Exception.Description
+			}
+			pl.add("exception: %s", msg)
+		case *network.EventRequestWillBeSent:
+			pl.mu.Lock()
+			if pl.urls == nil {
+				pl.urls = make(map[network.RequestID]string)
+			}
+			pl.urls[ev.RequestID] = ev.Request.URL
+			pl.mu.Unlock()
+		case *network.EventResponseReceived:
+			if ev.Response.Status == http.StatusTooManyRequests {
+				atomic.AddInt32(&s.tooManyRequests, 1)
+			}
+			if ev.Response.Status >= 400 {
+				pl.add("network: %d %s", ev.Response.Status, ev.Response.URL)
+			}
+		case *network.EventLoadingFinished:
+			pl.mu.Lock()
+			delete(pl.urls, ev.RequestID)
+			pl.mu.Unlock()
+		case *network.EventLoadingFailed:
+			pl.mu.Lock()
+			url := pl.urls[ev.RequestID]
+			delete(pl.urls, ev.RequestID)
+			pl.mu.Unlock()
+			if ev.Canceled {
+				return
+			}
+			pl.add("network: %s failed: %s", url, ev.ErrorText)
+		}
+	})
+}
+
+// writeDebugBundle captures the state of the page (a screenshot, the DOM, the
+// URL, and the recent page log), when reason happened, into
+// dlDir/debug/<timestamp>/, so users can attach it to their bug reports.
+func (s *Session) writeDebugBundle(ctx context.Context, reason error) {
+	dir := filepath.Join(s.dlDir, debugDir, time.Now().Format("20060102-150405"))
+	if err := s.debugBundle(ctx, dir, reason); err != nil {
+		log.Printf("Error writing debug bundle in %v: %v", dir, err)
+		return
+	}
+	log.Printf("Debug bundle written in %v", dir)
+}
+
+func (s *Session) debugBundle(ctx context.Context, dir string, reason error) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	var location, html string
+	var scr []byte
+	if err := chromedp.Run(ctx,
+		chromedp.Location(&location),
+		chromedp.Evaluate(`document.documentElement.outerHTML`, &html),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			scr, err = fullScreenshot(ctx)
+			return err
+		}),
+	); err != nil {
+		return err
+	}
+	info := fmt.Sprintf("error: %v\nurl: %s\ntime: %s\n", reason, location, time.Now().Format(time.RFC3339))
+	for name, data := range map[string][]byte{
+		"error.txt":      []byte(info),
+		"dom.html":       []byte(html),
+		"screenshot.png": scr,
+		"log.txt":        []byte(strings.Join(s.pageLog.lines(), "\n") + "\n"),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fullScreenshot returns a PNG screenshot of the whole page, and not only of
+// its visible part.
+func fullScreenshot(ctx context.Context) ([]byte, error) {
+	_, _, contentSize, err := page.GetLayoutMetrics().Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	width, height := int64(math.Ceil(contentSize.Width)), int64(math.Ceil(contentSize.Height))
+	return page.CaptureScreenshot().
+		WithClip(&page.Viewport{
+			X:      contentSize.X,
+			Y:      contentSize.Y,
+			Width:  float64(width),
+			Height: float64(height),
+			Scale:  1,
+		}).Do(ctx)
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// hashesFile is the name of the file, in the state directory, where we
+// record the SHA-256 of the contents of the downloaded files, with -dedupe,
+// one JSON object per line.
+const hashesFile = ".hashes"
+
+// hashEntry is a line of hashesFile.
+type hashEntry struct {
+	Sum string `json:"sum"`
+	// File is the path, relative to topDlDir, of the file.
+	File string `json:"file"`
+}
+
+// loadHashes sets s.hashes from hashesFile. The first time, the files
+// already in the ledgers, of the user's items and of the partner's, are
+// hashed and recorded, so that the downloads of their duplicates are caught
+// too.
+func (s *Session) loadHashes() error {
+	s.hashes = make(map[string]string)
+	hashed := make(map[string]bool)
+	f, err := os.Open(filepath.Join(s.topStateDir, hashesFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for line := 1; sc.Scan(); line++ {
+			if len(sc.Bytes()) == 0 {
+				continue
+			}
+			var e hashEntry
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				return fmt.Errorf("%v:%d: %v", hashesFile, line, err)
+			}
+			if _, ok := s.hashes[e.Sum]; !ok {
+				s.hashes[e.Sum] = e.File
+			}
+			hashed[e.File] = true
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+	}
+	// the paths of the partner ledger are relative to partnerDir.
+	for _, dir := range []string{"", partnerDir} {
+		entries, err := loadLedger(filepath.Join(s.topStateDir, dir))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			for _, file := range e.Files {
+				rel := path.Join(filepath.ToSlash(dir), file)
+				if hashed[rel] {
+					continue
+				}
+				hashed[rel] = true
+				mf := &mediaFile{path: filepath.Join(s.topDlDir, filepath.FromSlash(rel))}
+				sum, err := mf.sum()
+				if os.IsNotExist(err) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if err := s.appendHash(sum, rel); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// appendHash records in hashesFile that the file at rel, relative to
+// topDlDir, has the contents of SHA-256 sum.
+func (s *Session) appendHash(sum, rel string) error {
+	data, err := json.Marshal(hashEntry{Sum: sum, File: rel})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.topStateDir, hashesFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if _, ok := s.hashes[sum]; !ok {
+		s.hashes[sum] = rel
+	}
+	return nil
+}
+
+// dedupe, with -dedupe, looks for files with the same contents as the newly
+// stored paths elsewhere in topDlDir, i.e. among the user's items and the
+// partner's. With -dedupe hardlink, such a path is replaced with a hard link
+// to the other file. With -dedupe skip, it is removed, and the other file
+// takes its place in the returned paths, so the ledger then records the file
+// of another item for this one.
+func (s *Session) dedupe(paths []string) ([]string, error) {
+	if *dedupeFlag == "" {
+		return paths, nil
+	}
+	s.muHashes.Lock()
+	defer s.muHashes.Unlock()
+	if s.hashes == nil {
+		if err := s.loadHashes(); err != nil {
+			return nil, err
+		}
+	}
+	deduped := make([]string, 0, len(paths))
+	for _, path := range paths {
+		rel, err := filepath.Rel(s.topDlDir, path)
+		if err != nil {
+			return nil, err
+		}
+		rel = filepath.ToSlash(rel)
+		mf := &mediaFile{path: path}
+		sum, err := mf.sum()
+		if err != nil {
+			return nil, err
+		}
+		other, ok := s.hashes[sum]
+		otherPath := filepath.Join(s.topDlDir, filepath.FromSlash(other))
+		if ok && other != rel {
+			if _, err := os.Stat(otherPath); err != nil {
+				// gone since, so path becomes the reference.
+				ok = false
+				delete(s.hashes, sum)
+			}
+		}
+		if !ok {
+			if err := s.appendHash(sum, rel); err != nil {
+				return nil, err
+			}
+			deduped = append(deduped, path)
+			continue
+		}
+		if other == rel {
+			deduped = append(deduped, path)
+			continue
+		}
+		if *dedupeFlag == "skip" {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+			if *verboseFlag {
+				log.Printf("%v has the same contents as %v, not keeping it", path, otherPath)
+			}
+			s.summary.addDeduped()
+			deduped = append(deduped, otherPath)
+			continue
+		}
+		tmpPath := path + ".tmp"
+		if err := os.Link(otherPath, tmpPath); err != nil {
+			// e.g. not supported by the file system, so we keep the copy.
+			log.Printf("Error hard linking %v to %v: %v", path, otherPath, err)
+			deduped = append(deduped, path)
+			continue
+		}
+		if err := os.Rename(tmpPath, path); err != nil {
+			return nil, err
+		}
+		if *verboseFlag {
+			log.Printf("%v has the same contents as %v, hard linked", path, otherPath)
+		}
+		s.summary.addDeduped()
+		deduped = append(deduped, path)
+	}
+	return deduped, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// devtoolsCookie is the cookie that authenticates to the -devtools proxy,
+// once the token has been presented.
+const devtoolsCookie = "gphotos-cdp-devtools"
+
+// devtoolsProxy exposes the remote debugging of the browser, which only
+// listens on localhost, on -devtools, to the holders of its token.
+type devtoolsProxy struct {
+	token string
+	// chrome is the local address of the remote debugging of the browser.
+	chrome string
+	proxy  *httputil.ReverseProxy
+}
+
+// startDevtools picks a local port for the remote debugging of the browser
+// of s, starts the proxy exposing it on addr, and prints the URL to open to
+// inspect the page.
+func startDevtools(s *Session, addr string) error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	s.debugPort = l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return err
+	}
+	d := &devtoolsProxy{
+		token:  hex.EncodeToString(token[:]),
+		chrome: "127.0.0.1:" + strconv.Itoa(s.debugPort),
+	}
+	target := &url.URL{Scheme: "http", Host: d.chrome}
+	d.proxy = httputil.NewSingleHostReverseProxy(target)
+	director := d.proxy.Director
+	d.proxy.Director = func(r *http.Request) {
+		director(r)
+		// Chrome rejects the requests for other hosts, and the
+		// WebSocket connections from other origins.
+		r.Host = d.chrome
+		r.Header.Del("Origin")
+		r.Header.Del("Cookie")
+	}
+
+	dl, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	host, port, _ := net.SplitHostPort(dl.Addr().String())
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		if host, err = os.Hostname(); err != nil {
+			host = "localhost"
+		}
+	}
+	fmt.Fprintf(userOutput(), "To watch the browser, open http://%v/?token=%v in Chrome\n", net.JoinHostPort(host, port), d.token)
+	go func() {
+		log.Fatal(http.Serve(dl, d))
+	}()
+	return nil
+}
+
+func (d *devtoolsProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if token := r.URL.Query().Get("token"); token != "" {
+		if !d.valid(token) {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: devtoolsCookie, Value: token, Path: "/", HttpOnly: true})
+	} else if c, err := r.Cookie(devtoolsCookie); err != nil || !d.valid(c.Value) {
+		http.Error(w, "missing token", http.StatusForbidden)
+		return
+	}
+	if r.URL.Path != "/" {
+		d.proxy.ServeHTTP(w, r)
+		return
+	}
+	inspect, err := d.inspectURL(r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	http.Redirect(w, r, inspect, http.StatusFound)
+}
+
+// valid reports whether token is the token of d.
+func (d *devtoolsProxy) valid(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(d.token)) == 1
+}
+
+// inspectURL returns the path of the DevTools of the page of the browser, on
+// host, the address of the proxy.
+func (d *devtoolsProxy) inspectURL(host string) (string, error) {
+	resp, err := http.Get("http://" + d.chrome + "/json/list")
+	if err != nil {
+		return "", fmt.Errorf("browser not running: %v", err)
+	}
+	defer resp.Body.Close()
+	var targets []struct {
+		Type                string `json:"type"`
+		URL                 string `json:"url"`
+		DevtoolsFrontendURL string `json:"devtoolsFrontendUrl"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
+		return "", err
+	}
+	for _, t := range targets {
+		if t.Type == "page" && t.DevtoolsFrontendURL != "" {
+			return strings.Replace(t.DevtoolsFrontendURL, d.chrome, host, 1), nil
+		}
+	}
+	return "", fmt.Errorf("no page in the browser")
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// dismissDialogsJS clicks the dismiss button of each visible consent or
+// promotional dialog of the page, and returns the labels of the clicked
+// buttons. These dialogs are recognized by their selectors, or by the words
+// of their title, so the other dialogs, e.g. the confirmation of a deletion,
+// are left alone. On the consent pages (consent.google.com), where the
+// consent form is the whole page, it looks for the buttons in the whole
+// document.
+const dismissDialogsJS = `(function() {
+	const labels = [
+		"reject all", "accept all", "i agree", "no thanks", "no, thanks", "not now",
+		"got it", "dismiss", "close", "skip", "ok", "done", "maybe later",
+	];
+	const visible = (el) => {
+		const r = el.getBoundingClientRect();
+		return r.width > 0 && r.height > 0 && getComputedStyle(el).visibility !== "hidden";
+	};
+	// full-screen "dialogs", such as the photo viewer itself, are not the
+	// kind we want to close.
+	const fullScreen = (el) => {
+		const r = el.getBoundingClientRect();
+		return r.width >= 0.9 * window.innerWidth && r.height >= 0.9 * window.innerHeight;
+	};
+	const knownSelectors = 'form[action*="consent"], [aria-label*="cookie" i], [aria-label*="consent" i]';
+	const knownTitles = /cookies|consent|before you continue|privacy|google one|storage|upgrade|get the app|what's new|new in|introducing|try the|memories|tips?\b/i;
+	const title = (el) => {
+		const id = el.getAttribute("aria-labelledby");
+		const labelledBy = id ? document.getElementById(id) : null;
+		const heading = el.querySelector('h1, h2, h3, [role="heading"]');
+		return el.getAttribute("aria-label") || (labelledBy && labelledBy.innerText) || (heading && heading.innerText) || "";
+	};
+	const known = (el) => el.matches(knownSelectors) || el.querySelector(knownSelectors) !== null || knownTitles.test(title(el));
+	let containers = Array.from(document.querySelectorAll('[role="dialog"], [role="alertdialog"], [aria-modal="true"]')).filter((el) => visible(el) && !fullScreen(el) && known(el));
+	if (location.hostname === "consent.google.com") {
+		containers = [document];
+	}
+	const clicked = [];
+	for (const c of containers) {
+		const buttons = Array.from(c.querySelectorAll('button, [role="button"], input[type="submit"]')).filter(visible);
+		let best = null, bestRank = labels.length;
+		for (const b of buttons) {
+			const text = (b.getAttribute("aria-label") || b.innerText || b.value || "").trim().toLowerCase();
+			const rank = labels.indexOf(text);
+			if (rank >= 0 && rank < bestRank) {
+				best = b;
+				bestRank = rank;
+			}
+		}
+		if (best !== null) {
+			best.click();
+			clicked.push(labels[bestRank]);
+		}
+	}
+	return clicked;
+})()`
+
+// dismissDialogs closes the consent, cookie, and promotional dialogs that
+// would otherwise swallow our keyboard events. Since closing a dialog
+// sometimes reveals another one, it retries until no more dialogs are found,
+// a few times at most.
+func dismissDialogs(ctx context.Context) error {
+	defer traceStep("dismiss dialogs", "")()
+	for i := 0; i < 3; i++ {
+		var clicked []string
+		if err := chromedp.Evaluate(dismissDialogsJS, &clicked).Do(ctx); err != nil {
+			return err
+		}
+		if len(clicked) == 0 {
+			return nil
+		}
+		log.Printf("Dismissed dialogs with: %v", clicked)
+		time.Sleep(tick)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Values of -direction.
+const (
+	directionOldest = "oldest"
+	directionNewest = "newest"
+)
+
+// newestFirst reports whether we download the most recent items first.
+func newestFirst() bool {
+	return *directionFlag == directionNewest
+}
+
+// navNext navigates to the next item to download: the more recent one (to
+// the left), or the older one (to the right) with -direction newest, or with
+// -order recently-added.
+func (s *Session) navNext(ctx context.Context) error {
+	if newestFirst() || recentlyAdded() {
+		return navRight(ctx)
+	}
+	return navLeft(ctx)
+}
+
+// endItem returns the ID of the last item to download, or the empty string
+// if it is not known in advance, as with -order recently-added.
+func (s *Session) endItem() string {
+	if recentlyAdded() {
+		return ""
+	}
+	if newestFirst() {
+		return s.oldestItem
+	}
+	return s.firstItem
+}
+
+// firstNavNewest is firstNav for -direction newest. It first goes to the end
+// of the timeline to find the oldest item, which is where we stop, and then
+// navigates to the -start item, or to the most recent one.
+func (s *Session) firstNavNewest(ctx context.Context) error {
+	if _, err := s.estimateRemaining(ctx, 0); err != nil {
+		return err
+	}
+	if err := navToEnd(ctx); err != nil {
+		return err
+	}
+	if err := s.navToLast(ctx); err != nil {
+		return err
+	}
+	var location string
+	if err := chromedp.Location(&location).Do(ctx); err != nil {
+		return err
+	}
+	oldest, err := itemID(location)
+	if err != nil {
+		return err
+	}
+	s.oldestItem = oldest
+	if *verboseFlag {
+		log.Printf("Oldest item in the feed is: %s", s.oldestItem)
+	}
+
+	start := *startFlag
+	if start == "" {
+		start = s.resumeFrom
+	}
+	if start == "" {
+		start = strings.TrimSuffix(s.photosURL, "/") + "/photo/" + s.firstItem
+	}
+	if err := chromedp.Navigate(start).Do(ctx); err != nil {
+		return err
+	}
+	if err := chromedp.WaitReady("body", chromedp.ByQuery).Do(ctx); err != nil {
+		return err
+	}
+	return dismissDialogs(ctx)
+}
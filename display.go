@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// displayProcs are the Xvfb and VNC server processes started by setupDisplay.
+var displayProcs []*exec.Cmd
+
+// setupDisplay starts, with -display-setup, when there is no X display, an
+// Xvfb virtual display for the browser, and a VNC server on it, on
+// -vnc-port, so the user can watch it, and log in. It prints how to
+// connect to it.
+func setupDisplay() error {
+	if os.Getenv("DISPLAY") != "" {
+		log.Printf("Using the existing display %v", os.Getenv("DISPLAY"))
+		return nil
+	}
+	for _, prog := range []string{"Xvfb", "x11vnc"} {
+		if _, err := exec.LookPath(prog); err != nil {
+			return fmt.Errorf("-display-setup needs %v: %v", prog, err)
+		}
+	}
+	// the first free display number, from 99 as the others are usually
+	// the ones of the real displays.
+	n := 99
+	for ; n < 200; n++ {
+		if _, err := os.Stat(fmt.Sprintf("/tmp/.X%d-lock", n)); os.IsNotExist(err) {
+			break
+		}
+	}
+	display := ":" + strconv.Itoa(n)
+	xvfb := exec.Command("Xvfb", display, "-screen", "0", "1280x1024x24", "-nolisten", "tcp")
+	if err := xvfb.Start(); err != nil {
+		return fmt.Errorf("could not start Xvfb: %v", err)
+	}
+	displayProcs = append(displayProcs, xvfb)
+	socket := filepath.Join("/tmp/.X11-unix", "X"+strconv.Itoa(n))
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			stopDisplay()
+			return fmt.Errorf("Xvfb did not start on display %v", display)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	os.Setenv("DISPLAY", display)
+
+	var pw [6]byte
+	if _, err := rand.Read(pw[:]); err != nil {
+		stopDisplay()
+		return err
+	}
+	password := hex.EncodeToString(pw[:])
+	port := strconv.Itoa(*vncPortFlag)
+	vnc := exec.Command("x11vnc", "-display", display, "-forever", "-shared", "-quiet", "-rfbport", port, "-passwd", password)
+	if err := vnc.Start(); err != nil {
+		stopDisplay()
+		return fmt.Errorf("could not start x11vnc: %v", err)
+	}
+	displayProcs = append(displayProcs, vnc)
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	fmt.Fprintf(userOutput(), "The browser is on the virtual display %v. To watch it, connect to vnc://%v:%v with the password %v\n", display, host, port, password)
+	return nil
+}
+
+// stopDisplay stops the processes started by setupDisplay.
+func stopDisplay() {
+	for i := len(displayProcs) - 1; i >= 0; i-- {
+		p := displayProcs[i]
+		if err := p.Process.Kill(); err != nil {
+			log.Printf("Error stopping %v: %v", p.Path, err)
+		}
+		p.Wait()
+	}
+	displayProcs = nil
+}
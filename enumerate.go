@@ -0,0 +1,264 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+)
+
+// itemsFile is the name of the file, in the download directory, where
+// -enumerate lists all the items of the library, one JSON object per line.
+const itemsFile = "items.jsonl"
+
+// enumItem is an item of the library, as found in the responses of the
+// batchexecute RPC endpoint, with which the web app loads the timeline.
+type enumItem struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// MediaURL is the base URL of the item's media, for thumbnails.
+	MediaURL string    `json:"mediaURL"`
+	Taken    time.Time `json:"taken"`
+}
+
+// parseBatchExecute returns the items found in body, a response of the
+// batchexecute endpoint. Such a response is, after a )]}' line, a sequence of
+// lengths and JSON arrays. In the arrays, each "wrb.fr" entry carries the
+// JSON-encoded result of an RPC, which for the timeline is a list of items
+// like [ID, [mediaURL, width, height, ...], takenMillis, ...]. Rather than
+// relying on the RPC IDs, which change, any list of that shape is taken.
+func parseBatchExecute(body []byte) []enumItem {
+	body = bytes.TrimPrefix(body, []byte(")]}'"))
+	dec := json.NewDecoder(bytes.NewReader(body))
+	var items []enumItem
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			if err != io.EOF && *verboseFlag {
+				log.Printf("Error parsing batchexecute response: %v", err)
+			}
+			return items
+		}
+		entries, ok := v.([]interface{})
+		if !ok {
+			// a length
+			continue
+		}
+		for _, e := range entries {
+			e, ok := e.([]interface{})
+			if !ok || len(e) < 3 || e[0] != "wrb.fr" {
+				continue
+			}
+			result, ok := e[2].(string)
+			if !ok {
+				continue
+			}
+			var data []interface{}
+			if err := json.Unmarshal([]byte(result), &data); err != nil || len(data) == 0 {
+				continue
+			}
+			list, _ := data[0].([]interface{})
+			for _, it := range list {
+				if item, ok := parseEnumItem(it); ok {
+					items = append(items, item)
+				}
+			}
+		}
+	}
+}
+
+// parseEnumItem returns the item v is, if it has the shape of one.
+func parseEnumItem(v interface{}) (enumItem, bool) {
+	fields, ok := v.([]interface{})
+	if !ok || len(fields) < 3 {
+		return enumItem{}, false
+	}
+	id, _ := fields[0].(string)
+	media, _ := fields[1].([]interface{})
+	taken, _ := fields[2].(float64)
+	if !strings.HasPrefix(id, "AF1Qip") || len(media) == 0 || taken <= 0 {
+		return enumItem{}, false
+	}
+	mediaURL, _ := media[0].(string)
+	if !strings.HasPrefix(mediaURL, "https://") {
+		return enumItem{}, false
+	}
+	return enumItem{
+		ID:       id,
+		MediaURL: mediaURL,
+		Taken:    time.Unix(0, int64(taken)*int64(time.Millisecond)),
+	}, true
+}
+
+// enumerator collects the items from the batchexecute responses of a page.
+type enumerator struct {
+	photosURL string
+
+	mu      sync.Mutex
+	pending sync.WaitGroup
+	items   map[string]enumItem
+}
+
+// listen starts collecting the items from the responses of the page of ctx.
+func (en *enumerator) listen(ctx context.Context) {
+	var mu sync.Mutex
+	rpcs := make(map[network.RequestID]bool)
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *network.EventResponseReceived:
+			if strings.Contains(ev.Response.URL, "/batchexecute") {
+				mu.Lock()
+				rpcs[ev.RequestID] = true
+				mu.Unlock()
+			}
+		case *network.EventLoadingFinished:
+			mu.Lock()
+			rpc := rpcs[ev.RequestID]
+			delete(rpcs, ev.RequestID)
+			mu.Unlock()
+			if !rpc {
+				return
+			}
+			en.pending.Add(1)
+			// listeners must not block.
+			go func() {
+				defer en.pending.Done()
+				en.add(targetContext(ctx), ev.RequestID)
+			}()
+		}
+	})
+}
+
+// add collects the items of the response to the request id.
+func (en *enumerator) add(ctx context.Context, id network.RequestID) {
+	body, err := network.GetResponseBody(id).Do(ctx)
+	if err != nil {
+		if *verboseFlag {
+			log.Printf("Error getting batchexecute response: %v", err)
+		}
+		return
+	}
+	en.mu.Lock()
+	defer en.mu.Unlock()
+	if en.items == nil {
+		en.items = make(map[string]enumItem)
+	}
+	for _, it := range parseBatchExecute(body) {
+		it.URL = strings.TrimSuffix(en.photosURL, "/") + "/photo/" + it.ID
+		en.items[it.ID] = it
+	}
+}
+
+// enumerate lists all the items of the library, from the responses the web
+// app gets while we scroll down the timeline, which is much faster than
+// navigating from item to item, and writes them to the items file.
+func (s *Session) enumerate(ctx context.Context) error {
+	if !s.listening {
+		s.listen(ctx)
+	}
+	en := &enumerator{photosURL: s.photosURL}
+	en.listen(ctx)
+	if err := s.login(ctx); err != nil {
+		return err
+	}
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var previousScr, scr []byte
+		for {
+			keyEvent(ctx, kb.PageDown, focusKept)
+			time.Sleep(tick)
+			if err := chromedp.CaptureScreenshot(&scr).Do(ctx); err != nil {
+				return err
+			}
+			if previousScr != nil && bytes.Equal(previousScr, scr) {
+				return nil
+			}
+			previousScr = scr
+			en.mu.Lock()
+			found := len(en.items)
+			en.mu.Unlock()
+			total, err := s.estimateRemaining(ctx, found)
+			if err != nil {
+				return err
+			}
+			if *verboseFlag {
+				if total > 0 {
+					log.Printf("%d items found so far, of about %d", found, total)
+				} else {
+					log.Printf("%d items found so far", found)
+				}
+			}
+		}
+	})); err != nil {
+		return err
+	}
+	en.pending.Wait()
+
+	items := make([]enumItem, 0, len(en.items))
+	for _, it := range en.items {
+		items = append(items, it)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Taken.Before(items[j].Taken) })
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	missing := 0
+	for _, it := range items {
+		if err := enc.Encode(it); err != nil {
+			return err
+		}
+		if !s.downloaded[it.ID] {
+			missing++
+		}
+	}
+	path := filepath.Join(s.dlDir, itemsFile)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	log.Printf("Found %d items, %d of them not downloaded yet, listed in %v", len(items), missing, path)
+	return nil
+}
+
+// enumerateOnce runs enumerate for s, and returns the process exit code
+// matching its outcome.
+func (s *Session) enumerateOnce() int {
+	if err := s.loadDownloaded(); err != nil {
+		log.Print(err)
+		return exitCode(err)
+	}
+	if err := s.enumerate(s.ctx); err != nil {
+		log.Print(err)
+		return exitCode(err)
+	}
+	return 0
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/chromedp/chromedp"
+)
+
+// scrollGeometryJS returns the geometry of the scrolling element of the
+// timeline, the number of items fully visible in it, and the year labels of
+// the timeline scrubber, from top to bottom. The scrubber is the column of
+// labels along the right edge of the page.
+const scrollGeometryJS = `(function() {
+	let el = document.scrollingElement;
+	for (const e of document.querySelectorAll("c-wiz div, [role='main']")) {
+		if (e.clientHeight > 0 && e.scrollHeight > el.scrollHeight && e.scrollHeight > e.clientHeight + 10) {
+			el = e;
+		}
+	}
+	const view = el === document.scrollingElement ? {top: 0, bottom: window.innerHeight} : el.getBoundingClientRect();
+	let visible = 0;
+	for (const a of document.querySelectorAll('a[href*="/photo/"]')) {
+		const r = a.getBoundingClientRect();
+		if (r.width > 0 && r.top >= view.top && r.bottom <= view.bottom) {
+			visible++;
+		}
+	}
+	const years = [];
+	for (const e of document.querySelectorAll("div, span")) {
+		if (e.childElementCount !== 0 || !/^(19|20)\d\d$/.test(e.textContent.trim())) {
+			continue;
+		}
+		const r = e.getBoundingClientRect();
+		if (r.width > 0 && r.left > 0.8 * window.innerWidth) {
+			years.push({year: e.textContent.trim(), top: r.top});
+		}
+	}
+	years.sort((a, b) => a.top - b.top);
+	return {
+		visible: visible,
+		top: el.scrollTop,
+		height: el.scrollHeight,
+		client: el.clientHeight,
+		years: years.map((y) => y.year),
+	};
+})()`
+
+// scrollGeometry is the result of scrollGeometryJS.
+type scrollGeometry struct {
+	Visible int      `json:"visible"`
+	Top     float64  `json:"top"`
+	Height  float64  `json:"height"`
+	Client  float64  `json:"client"`
+	Years   []string `json:"years"`
+}
+
+// estimateItems returns how many items the timeline of geometry g has,
+// assuming they are evenly spread along it. When found items were already
+// collected while scrolling down to the current position, their number is
+// extrapolated to the whole extent. Otherwise the items visible are. It
+// returns 0 if it cannot tell.
+func estimateItems(found int, g scrollGeometry) int {
+	if g.Height <= 0 {
+		return 0
+	}
+	if found > 0 && g.Top+g.Client > 0 {
+		return int(float64(found) * g.Height / (g.Top + g.Client))
+	}
+	if g.Visible > 0 && g.Client > 0 {
+		return int(float64(g.Visible) * g.Height / g.Client)
+	}
+	return 0
+}
+
+// estimateRemaining estimates, from the timeline currently shown, how many
+// items of the library are not downloaded yet, given found, the items
+// collected so far if any, for the progress output and /status, until the
+// estimate is updated again. It returns the estimated number of items of
+// the library, or 0 if it cannot tell.
+func (s *Session) estimateRemaining(ctx context.Context, found int) (int, error) {
+	var g scrollGeometry
+	if err := chromedp.Evaluate(scrollGeometryJS, &g).Do(ctx); err != nil {
+		return 0, err
+	}
+	total := estimateItems(found, g)
+	if total == 0 {
+		return 0, nil
+	}
+	remaining := total - len(s.downloaded)
+	if remaining < 0 {
+		remaining = 0
+	}
+	s.muBeat.Lock()
+	s.estimated = true
+	s.remainingBase = remaining
+	s.remainingAt = s.summary.downloaded()
+	s.muBeat.Unlock()
+	if found == 0 {
+		span := ""
+		if n := len(g.Years); n > 0 {
+			// the scrubber goes from the most recent year down.
+			span = " (" + g.Years[n-1] + " to " + g.Years[0] + ")"
+		}
+		log.Printf("About %d items in the library%s, %d of them not downloaded yet", total, span, remaining)
+	}
+	return total, nil
+}
+
+// remaining returns the estimated number of items not downloaded yet, and
+// whether there is an estimate. It is safe to call from any goroutine.
+func (s *Session) remaining() (int, bool) {
+	s.muBeat.Lock()
+	defer s.muBeat.Unlock()
+	if !s.estimated {
+		return 0, false
+	}
+	n := s.remainingBase - (s.lastBeat.Done - s.remainingAt)
+	if n < 0 {
+		n = 0
+	}
+	return n, true
+}
+
+// progressEvery is how many downloaded items apart the progress is logged.
+const progressEvery = 100
+
+// logProgress logs the number of items downloaded so far, and the estimated
+// number remaining, every progressEvery items.
+func (s *Session) logProgress() {
+	n := s.summary.downloaded()
+	if n == 0 || n%progressEvery != 0 || n == s.progressLogged {
+		return
+	}
+	s.progressLogged = n
+	if remaining, ok := s.remaining(); ok {
+		log.Printf("Downloaded %d items, about %d remaining", n, remaining)
+		return
+	}
+	log.Printf("Downloaded %d items", n)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// infoLocationJS returns the coordinates, as "lat,lng", of the location shown
+// in the info panel, from the link to its map, or the empty string.
+const infoLocationJS = `(function() {
+	for (const a of document.querySelectorAll('a[href*="google.com/maps"]')) {
+		const m = a.href.match(/[?&@](?:q=)?(-?\d+\.\d+),(-?\d+\.\d+)/);
+		if (m) {
+			return m[1] + "," + m[2];
+		}
+	}
+	return "";
+})()`
+
+// parseLatLng parses the "lat,lng" returned by infoLocationJS.
+func parseLatLng(v string) (lat, lng float64, ok bool) {
+	if _, err := fmt.Sscanf(v, "%g,%g", &lat, &lng); err != nil {
+		return 0, 0, false
+	}
+	return lat, lng, true
+}
+
+// exifArgs returns the exiftool arguments that add the metadata of info to
+// the file at path, or nil if there is nothing to add, or if the format of the
+// file is not supported.
+func exifArgs(path string, info *itemInfo) []string {
+	var args []string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".heic", ".heif":
+		if info.HasLocation {
+			args = append(args,
+				fmt.Sprintf("-EXIF:GPSLatitude=%g", abs(info.Latitude)),
+				"-EXIF:GPSLatitudeRef="+hemisphere(info.Latitude, "N", "S"),
+				fmt.Sprintf("-EXIF:GPSLongitude=%g", abs(info.Longitude)),
+				"-EXIF:GPSLongitudeRef="+hemisphere(info.Longitude, "E", "W"),
+			)
+		}
+		if !info.Taken.IsZero() {
+			// EXIF dates have no time zone, so they are local time.
+			args = append(args, "-EXIF:DateTimeOriginal="+info.Taken.Format("2006:01:02 15:04:05"))
+		}
+		if info.Description != "" {
+			args = append(args, "-EXIF:ImageDescription="+info.Description, "-XMP-dc:Description="+info.Description)
+		}
+	case ".mp4", ".mov", ".m4v":
+		if info.HasLocation {
+			args = append(args, fmt.Sprintf("-Keys:GPSCoordinates=%g, %g", info.Latitude, info.Longitude))
+		}
+		if !info.Taken.IsZero() {
+			args = append(args, "-api", "QuickTimeUTC", "-QuickTime:CreateDate="+info.Taken.UTC().Format("2006:01:02 15:04:05"))
+		}
+		if info.Description != "" {
+			args = append(args, "-XMP-dc:Description="+info.Description)
+		}
+	}
+	return args
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func hemisphere(v float64, positive, negative string) string {
+	if v < 0 {
+		return negative
+	}
+	return positive
+}
+
+// fixExif adds, with -fix-exif, the metadata of info that the files at paths
+// are missing, with exiftool. Existing tags are never overwritten. With
+// -fix-exif-orig, the untouched file is kept, with the .orig suffix.
+func fixExif(paths []string, info *itemInfo) error {
+	if !*fixExifFlag || info == nil {
+		return nil
+	}
+	for _, path := range paths {
+		args := exifArgs(path, info)
+		if len(args) == 0 {
+			continue
+		}
+		if *fixExifOrigFlag {
+			if err := copyFile(path, path+".orig"); err != nil {
+				return err
+			}
+		}
+		// -wm cg: only create tags, never edit the existing ones.
+		args = append([]string{"-q", "-wm", "cg", "-overwrite_original"}, append(args, path)...)
+		if out, err := exec.Command("exiftool", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("exiftool on %v: %v: %s", path, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// copyFile copies the file at src to dst.
+func copyFile(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
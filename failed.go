@@ -0,0 +1,293 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// failedFile is the name of the file, in the state directory, that keeps the queue of the
+// items that could not be downloaded, so they are retried at the end of the
+// run, and in the next runs.
+const failedFile = ".failed"
+
+// failedItem is an item of the failed queue.
+type failedItem struct {
+	Location string    `json:"location"`
+	Error    string    `json:"error"`
+	Failures int       `json:"failures"`
+	Last     time.Time `json:"last"`
+	// Deferred is whether the item was not downloaded because it was not
+	// selected by -only, -minsize, or -maxsize, rather than because of an
+	// error. Kind and Size, when known, are what the filters were applied to.
+	Deferred bool   `json:"deferred,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	// Unavailable is, when Google Photos said the item cannot be
+	// downloaded, the class of the item, e.g. "print order".
+	Unavailable string `json:"unavailable,omitempty"`
+}
+
+// loadFailed returns the failed queue stored in stateDir.
+func loadFailed(stateDir string) ([]failedItem, error) {
+	data, err := ioutil.ReadFile(filepath.Join(stateDir, failedFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var items []failedItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// saveFailed stores items as the failed queue in stateDir.
+func saveFailed(stateDir string, items []failedItem) error {
+	path := filepath.Join(stateDir, failedFile)
+	if len(items) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(items, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// itemError is an error of the download path that is specific to the item
+// being downloaded, e.g. a canceled download, or a corrupt file, rather than
+// to the browser or to the run.
+type itemError struct {
+	err error
+}
+
+func (e itemError) Error() string { return e.err.Error() }
+
+func (e itemError) Unwrap() error { return e.err }
+
+// itemErrorf returns an itemError formatted as with fmt.Errorf.
+func itemErrorf(format string, args ...interface{}) error {
+	return itemError{err: fmt.Errorf(format, args...)}
+}
+
+// isItemError reports whether err, returned while downloading an item, is
+// specific to that item, in which case the item is quarantined in the failed
+// queue and the run goes on, or whether the whole run should be aborted. The
+// item errors are the timeouts of the download, and the itemErrors.
+func isItemError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if exitCode(err) == exitDownloadTimeout {
+		return true
+	}
+	var ie itemError
+	return errors.As(err, &ie)
+}
+
+// quarantine adds the item at location to the failed queue, because of err.
+func (s *Session) quarantine(location string, err error) error {
+	log.Printf("Could not download %v, queued for a later retry: %v", location, err)
+	items, lerr := loadFailed(s.stateDir)
+	if lerr != nil {
+		return lerr
+	}
+	var unavailable unavailableError
+	errors.As(err, &unavailable)
+	for i, it := range items {
+		if it.Location == location {
+			items[i].Error = err.Error()
+			items[i].Failures++
+			items[i].Last = time.Now()
+			items[i].Unavailable = unavailable.reason
+			return saveFailed(s.stateDir, items)
+		}
+	}
+	items = append(items, failedItem{
+		Location:    location,
+		Error:       err.Error(),
+		Failures:    1,
+		Last:        time.Now(),
+		Unavailable: unavailable.reason,
+	})
+	return saveFailed(s.stateDir, items)
+}
+
+// deferItem adds the item at location, which was filtered out, to the failed
+// queue, so it is downloaded by a later run whose filters select it.
+func (s *Session) deferItem(location string, filtered filteredOutError) error {
+	log.Printf("Deferring %v: %v", location, filtered)
+	items, err := loadFailed(s.stateDir)
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		if it.Location == location {
+			return nil
+		}
+	}
+	items = append(items, failedItem{
+		Location: location,
+		Error:    filtered.Error(),
+		Last:     time.Now(),
+		Deferred: true,
+		Kind:     filtered.kind,
+		Size:     filtered.size,
+	})
+	return saveFailed(s.stateDir, items)
+}
+
+// retryFailed goes over the failed queue, and retries each of its items in a
+// fresh tab. Items that are successfully downloaded are removed from the
+// queue. The ones that fail again are recorded in the summary.
+func (s *Session) retryFailed(ctx context.Context) error {
+	items, err := loadFailed(s.stateDir)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	// e.g. quarantined while pipelined, then downloaded by a later run, or
+	// by the crawl of this one.
+	var queued []failedItem
+	for _, it := range items {
+		if s.inLedger(it.Location) {
+			if *verboseFlag {
+				log.Printf("Dropping %v from the failed queue, as it was downloaded since", it.Location)
+			}
+			continue
+		}
+		queued = append(queued, it)
+	}
+	items = queued
+	if len(items) == 0 {
+		return saveFailed(s.stateDir, nil)
+	}
+	log.Printf("Retrying %d previously failed items", len(items))
+	var remaining []failedItem
+	for i, it := range items {
+		if s.skipped(it.Location) {
+			log.Printf("Dropping %v from the failed queue, as it is in the skip list", it.Location)
+			s.summary.addSkip(it.Location)
+			s.printItem(it.Location, actionSkipped, nil, 0, nil)
+			continue
+		}
+		if it.Deferred && !(wantedKind(it.Kind) && s.wantedSize(it.Size)) {
+			remaining = append(remaining, it)
+			continue
+		}
+		if *dryRunFlag {
+			log.Printf("Would retry %v", it.Location)
+			remaining = append(remaining, it)
+			continue
+		}
+		err := s.retryItem(ctx, it.Location)
+		if err == nil {
+			log.Printf("Retry of %v succeeded", it.Location)
+			continue
+		}
+		var nonMedia nonMediaError
+		if errors.As(err, &nonMedia) {
+			log.Printf("Dropping %v from the failed queue, as it is %v", it.Location, nonMedia)
+			s.summary.addNonMedia(it.Location, nonMedia.reason)
+			s.printItem(it.Location, actionSkipped, nil, 0, nonMedia)
+			continue
+		}
+		var filtered filteredOutError
+		if errors.As(err, &filtered) {
+			it.Deferred, it.Kind, it.Size = true, filtered.kind, filtered.size
+			remaining = append(remaining, it)
+			continue
+		}
+		if !isItemError(err) {
+			remaining = append(remaining, items[i:]...)
+			if serr := saveFailed(s.stateDir, remaining); serr != nil {
+				log.Printf("Error saving failed queue: %v", serr)
+			}
+			return err
+		}
+		log.Printf("Retry of %v failed: %v", it.Location, err)
+		var unavailable unavailableError
+		errors.As(err, &unavailable)
+		it.Error = err.Error()
+		it.Unavailable = unavailable.reason
+		it.Deferred = false
+		it.Failures++
+		it.Last = time.Now()
+		remaining = append(remaining, it)
+		s.summary.addFailure(it.Location, err)
+		s.printItem(it.Location, actionFailed, nil, 0, err)
+	}
+	return saveFailed(s.stateDir, remaining)
+}
+
+// retryItem opens location in a new tab, and downloads the item from there.
+func (s *Session) retryItem(ctx context.Context, location string) error {
+	s.startItem(location)
+	t := s.takeTab(ctx)
+	defer t.cancel()
+	tabCtx := t.ctx
+	// even for a tab of the pool, as the rate limit may have changed since
+	// it was warmed up.
+	if err := chromedp.Run(tabCtx,
+		s.prepareTarget(),
+		chromedp.Navigate(location),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+	); err != nil {
+		return err
+	}
+	// let the viewer settle, so it gets our key events.
+	time.Sleep(settle())
+	var filePaths []string
+	var info *itemInfo
+	err := chromedp.Run(tabCtx, chromedp.ActionFunc(func(ctx context.Context) error {
+		if err := dismissDialogs(ctx); err != nil {
+			return err
+		}
+		var err error
+		filePaths, info, err = s.dlAndMove(ctx, location)
+		return err
+	}))
+	if err != nil {
+		if exitCode(err) == exitDownloadTimeout {
+			s.writeDebugBundle(tabCtx, err)
+		}
+		return err
+	}
+	return s.postProcess(filePaths, location, info)
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// filenamesFile is the name of the file, in dlDir, mapping each stored file to
+// the original names of the item, for the migrations to other services.
+const filenamesFile = "filenames.csv"
+
+// writeFilenames rewrites the filenames file from the ledger, with, for each
+// file of each item: the ID of the item, the path of the file relative to
+// dlDir, the name the browser gave to the download, and the name shown in the
+// info panel with -metadata, which can differ for the edited items.
+func (s *Session) writeFilenames() error {
+	entries, err := loadLedger(s.stateDir)
+	if err != nil {
+		return err
+	}
+	filenamesPath := filepath.Join(s.dlDir, filenamesFile)
+	tmpPath := filenamesPath + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"id", "path", "download_name", "original_name"})
+	for _, e := range entries {
+		for i, file := range e.Files {
+			// the items downloaded in bulk, or extracted from an
+			// archive, keep their names.
+			name := path.Base(file)
+			if i < len(e.Names) && e.Names[i] != "" {
+				name = e.Names[i]
+			}
+			w.Write([]string{e.ID, file, name, e.Original})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filenamesPath)
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/chromedp"
+	"github.com/mailru/easyjson/jwriter"
+)
+
+// Kinds of items, as selected with -only, and as recorded in the ledger.
+// Motion photos are photos with a short video, and creations are what Google
+// Photos makes from the other items: animations, collages, movies, and
+// cinematic photos.
+const (
+	kindPhoto    = "photo"
+	kindVideo    = "video"
+	kindMotion   = "motion"
+	kindCreation = "creation"
+)
+
+// errFilteredOut is returned when an item is not of the kind selected with
+// -only and -skip-creations, or when its size is not within -minsize and
+// -maxsize.
+var errFilteredOut = errors.New("not selected by -only, -skip-creations, -minsize, or -maxsize")
+
+// filteredOutError is an errFilteredOut for an item of the given kind and
+// size. The size is zero if it was not the reason the item was filtered out.
+type filteredOutError struct {
+	kind string
+	size int64
+}
+
+func (e filteredOutError) Error() string {
+	if e.size > 0 {
+		return fmt.Sprintf("item of %d bytes %v", e.size, errFilteredOut)
+	}
+	return fmt.Sprintf("%s %v", e.kind, errFilteredOut)
+}
+
+func (e filteredOutError) Is(target error) bool { return target == errFilteredOut }
+
+// wantedKind reports whether items of the given kind should be downloaded,
+// according to -only and -skip-creations. An unknown (empty) kind is always
+// wanted.
+func wantedKind(kind string) bool {
+	if kind == kindCreation && *skipCreationsFlag {
+		return false
+	}
+	switch *onlyFlag {
+	case "photos":
+		return kind != kindVideo
+	case "videos":
+		return kind != kindPhoto && kind != kindMotion
+	}
+	return true
+}
+
+// wantedSize reports whether items of the given size should be downloaded,
+// according to -minsize and -maxsize. An unknown (zero) size is always wanted.
+func (s *Session) wantedSize(size int64) bool {
+	if size <= 0 {
+		return true
+	}
+	if s.minSize > 0 && size < s.minSize {
+		return false
+	}
+	if s.maxSize > 0 && size > s.maxSize {
+		return false
+	}
+	return true
+}
+
+// fileKind returns the kind of an item guessed from its filename. Archives
+// (e.g. a motion photo, or both versions of an item) are of unknown kind.
+func fileKind(filename string) string {
+	if isVideo(filename) {
+		return kindVideo
+	}
+	if strings.EqualFold(filepath.Ext(filename), ".zip") {
+		return ""
+	}
+	return kindPhoto
+}
+
+// itemKindJS returns the kind of the currently viewed item, from the hints of
+// the viewer: a creation has the buttons to edit it as such (e.g. "Edit
+// movie"), or the label of its type (e.g. "Animation"); a motion photo has the
+// button to turn its motion on or off; a video has a video player; and a photo
+// is a large image. It returns the empty string if it cannot tell.
+const itemKindJS = `(function() {
+	const visible = (el) => {
+		const r = el.getBoundingClientRect();
+		return r.width > 0 && r.height > 0;
+	};
+	const creationRx = /^(edit (movie|animation|collage)|animation|collage|movie|cinematic photo|highlight video)$/i;
+	for (const el of document.querySelectorAll('button, [role="button"], [aria-label], span, div')) {
+		if (el.children.length > 0 || !visible(el)) {
+			continue;
+		}
+		const text = (el.getAttribute("aria-label") || el.innerText || "").trim();
+		if (creationRx.test(text)) {
+			return "creation";
+		}
+	}
+	for (const el of document.querySelectorAll('[aria-label*="motion" i]')) {
+		if (visible(el)) {
+			return "motion";
+		}
+	}
+	for (const el of document.querySelectorAll('video, [aria-label*="Play video" i]')) {
+		if (visible(el)) {
+			return "video";
+		}
+	}
+	for (const el of document.querySelectorAll('img')) {
+		const r = el.getBoundingClientRect();
+		if (r.width > window.innerWidth / 2 || r.height > window.innerHeight / 2) {
+			return "photo";
+		}
+	}
+	return "";
+})()`
+
+// itemKind returns the kind of the currently viewed item. The creations and
+// motion photos are only told apart by the page, while for the others the
+// filename in info, when available, is more reliable.
+func itemKind(ctx context.Context, info *itemInfo) (string, error) {
+	var kind string
+	if err := chromedp.Evaluate(itemKindJS, &kind).Do(ctx); err != nil {
+		return "", err
+	}
+	if kind != kindCreation && kind != kindMotion && info != nil && info.Filename != "" {
+		kind = fileKind(info.Filename)
+	}
+	return kind, nil
+}
+
+// checkFilters returns the kind of the currently viewed item, and a
+// filteredOutError if it is not of the kind selected with -only and
+// -skip-creations, or if info says its size is not within -minsize and
+// -maxsize. If the kind or size cannot be determined yet, it returns a nil
+// error, and they are checked again during the download.
+func (s *Session) checkFilters(ctx context.Context, info *itemInfo) (string, error) {
+	kind, err := itemKind(ctx, info)
+	if err != nil {
+		return "", err
+	}
+	if info != nil && !s.wantedSize(info.Size) {
+		return kind, filteredOutError{kind: kind, size: info.Size}
+	}
+	if !wantedKind(kind) {
+		return kind, filteredOutError{kind: kind}
+	}
+	return kind, nil
+}
+
+// cancelDownloadParams are the parameters of the Browser.cancelDownload
+// command, which our version of cdproto does not know about.
+type cancelDownloadParams struct {
+	GUID string `json:"guid"`
+}
+
+func (p *cancelDownloadParams) MarshalEasyJSON(w *jwriter.Writer) {
+	w.RawString(`{"guid":`)
+	w.String(p.GUID)
+	w.RawByte('}')
+}
+
+// cancelDownload cancels the download with the given GUID.
+func cancelDownload(ctx context.Context, guid string) {
+	if err := cdp.Execute(ctx, "Browser.cancelDownload", &cancelDownloadParams{GUID: guid}, nil); err != nil {
+		// not fatal, the file will be removed by the next cleanStaging.
+		log.Printf("Could not cancel download %v: %v", guid, err)
+	}
+}
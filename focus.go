@@ -0,0 +1,81 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/chromedp"
+)
+
+// gridFocusPointJS returns a point of the timeline grid, in the margin left
+// of its first item, where a click gives the focus to the grid without
+// opening or selecting an item, or null if there is no such margin.
+const gridFocusPointJS = `(function() {
+	const a = Array.from(document.querySelectorAll('a[href*="/photo/"]')).find((el) => {
+		const r = el.getBoundingClientRect();
+		return r.width > 0 && r.height > 0;
+	});
+	if (!a) {
+		return null;
+	}
+	const r = a.getBoundingClientRect();
+	if (r.left < 8) {
+		return null;
+	}
+	return {x: r.left - 4, y: r.top + r.height / 2};
+})()`
+
+// point is a position in the page, in CSS pixels.
+type point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// focusGrid clicks next to the first item of the grid, so the grid gets the
+// keyboard focus back from the overlays of the fresh profiles, which swallow
+// the key events otherwise.
+func focusGrid(ctx context.Context) error {
+	var p *point
+	if err := chromedp.Evaluate(gridFocusPointJS, &p).Do(ctx); err != nil {
+		return err
+	}
+	if p == nil {
+		return nil
+	}
+	if *verboseFlag {
+		log.Printf("Clicking at %v,%v to focus the grid", p.X, p.Y)
+	}
+	return clickAt(ctx, *p)
+}
+
+// clickAt sends the mouse events of a left click at p.
+func clickAt(ctx context.Context, p point) error {
+	rec.action("click", "")
+	for _, typ := range []input.MouseType{input.MouseMoved, input.MousePressed, input.MouseReleased} {
+		ev := input.DispatchMouseEvent(typ, p.X, p.Y)
+		if typ != input.MouseMoved {
+			ev = ev.WithButton(input.Left).WithClickCount(1)
+		}
+		if err := ev.Do(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/chromedp"
+)
+
+// freedFile is the state file where -free-up records the IDs of the items it
+// moved to the trash, one per line, so -trash does not report them as
+// deletions.
+const freedFile = ".freed"
+
+// confirmTrashJS clicks the confirmation button of the "Move to trash" dialog,
+// and reports whether it found it.
+const confirmTrashJS = `(function() {
+	for (const b of document.querySelectorAll('[role="dialog"] button, [role="alertdialog"] button')) {
+		const r = b.getBoundingClientRect();
+		const text = (b.innerText || "").trim().toLowerCase();
+		if (r.width > 0 && r.height > 0 && (text === "move to trash" || text === "move to bin")) {
+			b.click();
+			return true;
+		}
+	}
+	return false;
+})()`
+
+// verifyDownload checks that the files of e, downloaded during this run, are
+// safely stored: on disk, not empty, and not an error page, or uploaded with
+// -upload.
+func (s *Session) verifyDownload(e ledgerEntry) error {
+	if s.uploads == nil {
+		return verifyFiles(s.dlDir, e.Files)
+	}
+	if len(e.Files) == 0 {
+		return errors.New("no files")
+	}
+	for _, f := range e.Files {
+		if !s.uploads.isUploaded(filepath.Join(s.dlDir, filepath.FromSlash(f))) {
+			return fmt.Errorf("%v not uploaded", f)
+		}
+	}
+	if *uploadDeleteFlag {
+		return nil
+	}
+	return verifyFiles(s.dlDir, e.Files)
+}
+
+// verifyFiles checks that files, the paths relative to dlDir of the files of
+// an item, are on disk, not empty, and not an error page.
+func verifyFiles(dlDir string, files []string) error {
+	if len(files) == 0 {
+		return errors.New("no files")
+	}
+	for _, f := range files {
+		path := filepath.Join(dlDir, filepath.FromSlash(f))
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if fi.Size() == 0 {
+			return fmt.Errorf("%v is empty", f)
+		}
+		r, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		head := make([]byte, 512)
+		n, _ := r.Read(head)
+		r.Close()
+		if ct := http.DetectContentType(head[:n]); strings.HasPrefix(ct, "text/") {
+			return fmt.Errorf("%v is %v, not media", f, ct)
+		}
+	}
+	return nil
+}
+
+// freeUp, with -free-up, moves to the trash of Google Photos the items
+// downloaded during this run, once their download is verified. Without
+// -free-up-confirm, it only logs the items it would move.
+func (s *Session) freeUp(ctx context.Context) error {
+	if !*freeUpFlag {
+		return nil
+	}
+	entries, err := loadLedger(s.stateDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Time.Before(s.summary.Start) {
+			continue
+		}
+		if err := s.verifyDownload(e); err != nil {
+			log.Printf("Not moving %v to the trash, its download could not be verified: %v", e.URL, err)
+			continue
+		}
+		if !*freeUpConfirmFlag || *dryRunFlag {
+			log.Printf("Would move %v to the trash (dry run, without -free-up-confirm)", e.URL)
+			continue
+		}
+		if err := s.trashItem(ctx, e); err != nil {
+			return fmt.Errorf("error moving %v to the trash: %w", e.URL, err)
+		}
+		if err := appendFreed(s.stateDir, e.ID); err != nil {
+			return err
+		}
+		log.Printf("Moved %v to the trash", e.URL)
+		s.summary.FreedUp++
+	}
+	return nil
+}
+
+// viewing reports whether the page of ctx views the item with the given ID,
+// and returns its location.
+func viewing(ctx context.Context, id string) (bool, string, error) {
+	var location string
+	if err := chromedp.Location(&location).Do(ctx); err != nil {
+		return false, "", err
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return false, location, err
+	}
+	return strings.HasSuffix(u.Path, "/photo/"+id), location, nil
+}
+
+// trashItem opens the item of e, and moves it to the trash, through the UI.
+// As this is destructive, it makes sure the viewer is at that item before
+// pressing the shortcut, and that it left the item afterwards.
+func (s *Session) trashItem(ctx context.Context, e ledgerEntry) error {
+	return chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		if err := navigate(ctx, e.URL); err != nil {
+			return err
+		}
+		// let the viewer settle, so it gets our key events.
+		time.Sleep(2 * tick)
+		ok, location, err := viewing(ctx, e.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%v opened %v instead", e.URL, location)
+		}
+		// # is the shortcut for deleting the item.
+		if err := keyPress(ctx, '#', input.ModifierShift); err != nil {
+			return err
+		}
+		for i := 0; i < 10; i++ {
+			time.Sleep(tick)
+			var clicked bool
+			if err := chromedp.Evaluate(confirmTrashJS, &clicked).Do(ctx); err != nil {
+				return err
+			}
+			if clicked {
+				return waitTrashed(ctx, e.ID)
+			}
+		}
+		return errors.New("no confirmation dialog")
+	}))
+}
+
+// waitTrashed waits for the viewer to leave the item with the given ID, as it
+// does once the item is in the trash.
+func waitTrashed(ctx context.Context, id string) error {
+	for i := 0; i < 10; i++ {
+		time.Sleep(tick)
+		ok, _, err := viewing(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+	return errors.New("the viewer did not leave the item after its move to the trash")
+}
+
+// appendFreed records id in the freed file in stateDir.
+func appendFreed(stateDir, id string) error {
+	f, err := os.OpenFile(filepath.Join(stateDir, freedFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, id); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadFreed returns the IDs recorded in the freed file in stateDir.
+func loadFreed(stateDir string) (map[string]bool, error) {
+	freed := make(map[string]bool)
+	f, err := os.Open(filepath.Join(stateDir, freedFile))
+	if os.IsNotExist(err) {
+		return freed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if id := strings.TrimSpace(sc.Text()); id != "" {
+			freed[id] = true
+		}
+	}
+	return freed, sc.Err()
+}
@@ -1,8 +1,9 @@
 module github.com/perkeep/gphotos-cdp
 
-go 1.12
+go 1.13
 
 require (
 	github.com/chromedp/cdproto v0.0.0-20200608134039-8a80cdaf865c
 	github.com/chromedp/chromedp v0.5.4-0.20200624114048-353306f986a8
+	github.com/mailru/easyjson v0.7.1
 )
@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// heartbeatFile is the name of the file, in the state directory, that we rewrite regularly
+// while working, so external watchdogs can tell when we are stuck.
+const heartbeatFile = ".heartbeat"
+
+// heartbeatInterval is the minimum time between two writes of the heartbeat
+// file for the same item.
+const heartbeatInterval = 10 * time.Second
+
+// heartbeat is the contents of the heartbeat file.
+type heartbeat struct {
+	Time time.Time `json:"time"`
+	// Item is the URL of the item being downloaded.
+	Item string `json:"item,omitempty"`
+	// Done is the number of items downloaded so far during the run.
+	Done int `json:"done"`
+	// State is "running", or "paused".
+	State string `json:"state"`
+	// Remaining is the estimated number of items not downloaded yet, if
+	// known.
+	Remaining *int `json:"remaining,omitempty"`
+}
+
+// heartbeat returns the last heartbeat of s. It is safe to call from any
+// goroutine.
+func (s *Session) heartbeat() heartbeat {
+	s.muBeat.Lock()
+	defer s.muBeat.Unlock()
+	return s.lastBeat
+}
+
+// beat writes the heartbeat file, with location as the current item, unless
+// it was already written for it less than heartbeatInterval ago.
+func (s *Session) beat(location, state string) {
+	s.clock.touch()
+	now := time.Now()
+	done := s.summary.downloaded()
+	s.muBeat.Lock()
+	if location == s.lastBeat.Item && state == s.lastBeat.State && now.Sub(s.lastBeat.Time) < heartbeatInterval {
+		s.muBeat.Unlock()
+		return
+	}
+	s.lastBeat = heartbeat{Time: now, Item: location, Done: done, State: state}
+	if s.estimated {
+		remaining := s.remainingBase - (done - s.remainingAt)
+		if remaining < 0 {
+			remaining = 0
+		}
+		s.lastBeat.Remaining = &remaining
+	}
+	data, err := json.Marshal(s.lastBeat)
+	s.muBeat.Unlock()
+	if err == nil {
+		path := filepath.Join(s.stateDir, heartbeatFile)
+		tmpPath := path + ".tmp"
+		if err = ioutil.WriteFile(tmpPath, data, 0600); err == nil {
+			err = os.Rename(tmpPath, path)
+		}
+	}
+	if err != nil {
+		log.Printf("Error writing heartbeat: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// inflightFile is the name of the file, in the state directory, where we keep track of the
+// downloads in progress, so we know which items they were for if we are
+// interrupted.
+const inflightFile = ".downloads"
+
+// errInterrupted is the error we quarantine an item with, when its download
+// was interrupted by the end of a previous run.
+var errInterrupted = errors.New("download interrupted by the end of the previous run")
+
+// inflightDownload is a download in progress, as recorded in inflightFile.
+type inflightDownload struct {
+	Location string    `json:"location"`
+	Filename string    `json:"filename"`
+	Started  time.Time `json:"started"`
+	// Dir is the staging directory of the download.
+	Dir string `json:"dir"`
+}
+
+func (s *Session) loadInflight() (map[string]inflightDownload, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.stateDir, inflightFile))
+	if os.IsNotExist(err) {
+		return map[string]inflightDownload{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	inflight := make(map[string]inflightDownload)
+	if err := json.Unmarshal(data, &inflight); err != nil {
+		return nil, err
+	}
+	return inflight, nil
+}
+
+func (s *Session) saveInflight(inflight map[string]inflightDownload) error {
+	path := filepath.Join(s.stateDir, inflightFile)
+	if len(inflight) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(inflight)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// startInflight records that the download dl, with the given GUID, for the
+// item at location, is in progress.
+func (s *Session) startInflight(guid, location string, dl dlProgress) error {
+	s.muInflight.Lock()
+	defer s.muInflight.Unlock()
+	inflight, err := s.loadInflight()
+	if err != nil {
+		return err
+	}
+	inflight[guid] = inflightDownload{Location: location, Filename: dl.filename, Started: time.Now(), Dir: dl.dir}
+	return s.saveInflight(inflight)
+}
+
+// endInflight records that the download with the given GUID is not in
+// progress anymore, whatever its outcome.
+func (s *Session) endInflight(guid string) {
+	s.muInflight.Lock()
+	defer s.muInflight.Unlock()
+	inflight, err := s.loadInflight()
+	if err == nil {
+		delete(inflight, guid)
+		err = s.saveInflight(inflight)
+	}
+	if err != nil {
+		log.Printf("Error updating %v: %v", inflightFile, err)
+	}
+}
+
+// recoverInterrupted looks for the partial files of the downloads that were
+// in progress when a previous run ended, and queues their items in the failed
+// queue, so they are downloaded again in this run. Chrome cannot resume such
+// downloads, so the partial files are then left for cleanStaging to remove.
+func (s *Session) recoverInterrupted() error {
+	inflight, err := s.loadInflight()
+	if err != nil {
+		return err
+	}
+	for guid, dl := range inflight {
+		staged := filepath.Join(dl.Dir, guid)
+		for _, path := range []string{staged, staged + ".crdownload"} {
+			fi, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			log.Printf("Found interrupted download of %v (%d bytes) for %v, it will be downloaded again", dl.Filename, fi.Size(), dl.Location)
+			break
+		}
+		// even without a partial file, as we do not know how far it got.
+		if err := s.quarantine(dl.Location, errInterrupted); err != nil {
+			return err
+		}
+	}
+	return s.saveInflight(nil)
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/perkeep/gphotos-cdp/internal/fakephotos"
+)
+
+// chromePath returns the path of the Chrome binary the tests run, or the
+// empty string if there is none.
+func chromePath() string {
+	if *chromeBinaryFlag != "" {
+		return *chromeBinaryFlag
+	}
+	for _, name := range []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser", "chrome", "headless-shell", "headless_shell"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// newTestSession returns a headless session, with its own profile and
+// download directories, crawling srv, and the func that cleans it all up,
+// which the caller defers. It skips the test when there is no Chrome to run.
+func newTestSession(t *testing.T, srv *fakephotos.Server) (*Session, func()) {
+	if testing.Short() {
+		t.Skip("integration test skipped in short mode")
+	}
+	if chromePath() == "" {
+		t.Skip("no Chrome to run the integration tests with")
+	}
+	dir, err := ioutil.TempDir("", "gphotos-cdp-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldProfile, oldDlDir, oldHeadless := *profileFlag, *dlDirFlag, *headlessFlag
+	restore := func() {
+		*profileFlag, *dlDirFlag, *headlessFlag = oldProfile, oldDlDir, oldHeadless
+		os.RemoveAll(dir)
+	}
+	*profileFlag = filepath.Join(dir, "profile")
+	*dlDirFlag = filepath.Join(dir, "dl")
+	*headlessFlag = true
+
+	s, err := NewSession("")
+	if err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	s.photosURL = srv.URL + "/"
+	s.NewContext()
+	return s, func() {
+		s.Shutdown()
+		restore()
+	}
+}
+
+func testItems(n int) []fakephotos.Item {
+	var items []fakephotos.Item
+	taken := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		items = append(items, fakephotos.Item{
+			ID:       "AF1QipFake" + string(rune('A'+i)),
+			Filename: "IMG_" + string(rune('A'+i)) + ".jpg",
+			Taken:    taken.AddDate(0, 0, i),
+		})
+	}
+	return items
+}
+
+// checkDownloaded fails t if any of items is not in its item directory, in
+// the ledger, or was downloaded more than once.
+func checkDownloaded(t *testing.T, s *Session, srv *fakephotos.Server, items []fakephotos.Item) {
+	t.Helper()
+	entries, err := loadLedger(s.stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inLedger := make(map[string]bool)
+	for _, e := range entries {
+		inLedger[e.ID] = true
+	}
+	for _, it := range items {
+		if _, err := os.Stat(filepath.Join(s.dlDir, it.ID, it.Filename)); err != nil {
+			t.Errorf("%v not downloaded: %v", it.ID, err)
+		}
+		if !inLedger[it.ID] {
+			t.Errorf("%v not in the ledger", it.ID)
+		}
+		if n := srv.Downloads(it.ID); n != 1 {
+			t.Errorf("%v downloaded %d times, want 1", it.ID, n)
+		}
+	}
+}
+
+func TestSyncAll(t *testing.T) {
+	items := testItems(3)
+	srv := fakephotos.New(items...)
+	defer srv.Close()
+	s, cleanup := newTestSession(t, srv)
+	defer cleanup()
+
+	if err := s.sync(s.ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkDownloaded(t, s, srv, items)
+	if s.summary.Downloaded != len(items) {
+		t.Errorf("summary reports %d items downloaded, want %d", s.summary.Downloaded, len(items))
+	}
+	lastDone, err := getLastDone(s.stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := srv.PhotoURL(items[len(items)-1].ID); lastDone != want {
+		t.Errorf("last done item is %q, want %q", lastDone, want)
+	}
+}
+
+func TestSyncIncremental(t *testing.T) {
+	items := testItems(3)
+	srv := fakephotos.New(items[:2]...)
+	defer srv.Close()
+	s, cleanup := newTestSession(t, srv)
+	defer cleanup()
+
+	if err := s.sync(s.ctx); err != nil {
+		t.Fatal(err)
+	}
+	srv.Add(items[2])
+	lastDone, err := getLastDone(s.stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.lastDone = lastDone
+	if err := s.sync(s.ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the last done item is navigated to again, but not downloaded again.
+	checkDownloaded(t, s, srv, items)
+	if s.summary.Downloaded != 1 {
+		t.Errorf("second sync downloaded %d items, want 1", s.summary.Downloaded)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	items := testItems(2)
+	srv := fakephotos.New(items...)
+	defer srv.Close()
+	s, cleanup := newTestSession(t, srv)
+	defer cleanup()
+	*dryRunFlag = true
+	defer func() { *dryRunFlag = false }()
+
+	if err := s.sync(s.ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range items {
+		if n := srv.Downloads(it.ID); n != 0 {
+			t.Errorf("%v downloaded %d times in dry run mode", it.ID, n)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(s.stateDir, ".lastdone")); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote .lastdone: %v", err)
+	}
+}
+
+func TestTrashItem(t *testing.T) {
+	items := testItems(3)
+	srv := fakephotos.New(items...)
+	defer srv.Close()
+	s, cleanup := newTestSession(t, srv)
+	defer cleanup()
+	// e.g. an item deleted since, whose URL now leads to the timeline.
+	srv.Redirect("/photo/"+items[0].ID, "/")
+
+	entry := func(it fakephotos.Item) ledgerEntry {
+		return ledgerEntry{ID: it.ID, URL: srv.PhotoURL(it.ID)}
+	}
+	if err := s.trashItem(s.ctx, entry(items[0])); err == nil {
+		t.Errorf("trashItem of a redirected item succeeded")
+	}
+	for _, it := range items {
+		if srv.Trashed(it.ID) {
+			t.Errorf("%v trashed, after the redirect", it.ID)
+		}
+	}
+	if err := s.trashItem(s.ctx, entry(items[1])); err != nil {
+		t.Fatal(err)
+	}
+	for i, it := range items {
+		if want := i == 1; srv.Trashed(it.ID) != want {
+			t.Errorf("%v trashed is %v, want %v", it.ID, !want, want)
+		}
+	}
+}
+
+func TestKeepAliveExpired(t *testing.T) {
+	srv := fakephotos.New(testItems(1)...)
+	defer srv.Close()
+	s, cleanup := newTestSession(t, srv)
+	defer cleanup()
+
+	if err := s.keepAlive(s.ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.expiredNotified {
+		t.Errorf("session reported as expired while logged in")
+	}
+	// where Google Photos sends the logged out sessions, on another host.
+	about := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1) + "/photos/about/"
+	srv.Redirect("/", about)
+	for i := 0; i < 2; i++ {
+		if err := s.keepAlive(s.ctx); err != nil {
+			t.Fatal(err)
+		}
+		if !s.expiredNotified {
+			t.Errorf("session not reported as expired after the redirect to %v", about)
+		}
+	}
+	srv.Redirect("/", "")
+	if err := s.keepAlive(s.ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.expiredNotified {
+		t.Errorf("session still reported as expired once logged in again")
+	}
+}
+
+func TestFakeServer(t *testing.T) {
+	srv := fakephotos.New(testItems(1)...)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL + "/download/AF1QipFakeA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, "IMG_A.jpg") {
+		t.Errorf("Content-Disposition is %q, want the filename", cd)
+	}
+	if n := srv.Downloads("AF1QipFakeA"); n != 1 {
+		t.Errorf("server counted %d downloads, want 1", n)
+	}
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fakephotos implements a fake Google Photos web app, for the
+// integration tests of gphotos-cdp. It only implements what gphotos-cdp relies
+// on: the timeline grid, with its keyboard focus, the viewer, with its
+// keyboard navigation (through the History API, like the real app), its
+// Shift+D download and Shift+# trash shortcuts, and the storage and Locked
+// Folder pages.
+package fakephotos
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Item is an item of the library.
+type Item struct {
+	ID       string
+	Filename string
+	Taken    time.Time
+	// Data is the content of the file. If nil, it is made up.
+	Data []byte
+}
+
+// data returns the content of the item's file.
+func (it Item) data() []byte {
+	if it.Data != nil {
+		return it.Data
+	}
+	// a JPEG start of image marker, so it is not mistaken for text.
+	return append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, "fake "+it.ID...)
+}
+
+// Server is a fake Google Photos, serving the library of its items.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	items     []Item // from the oldest to the most recent
+	downloads map[string]int
+	trashed   map[string]bool
+	redirects map[string]string
+}
+
+// New starts a Server, with items, given from the oldest to the most recent.
+// It must be closed with Close.
+func New(items ...Item) *Server {
+	s := &Server{
+		items:     items,
+		downloads: make(map[string]int),
+		trashed:   make(map[string]bool),
+		redirects: make(map[string]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/download/", s.serveDownload)
+	mux.HandleFunc("/trash/", s.serveTrash)
+	mux.HandleFunc("/", s.serveApp)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Add adds it to the library, as its most recent item.
+func (s *Server) Add(it Item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = append(s.items, it)
+}
+
+// Downloads returns how many times the item with the given ID was
+// downloaded.
+func (s *Server) Downloads(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.downloads[id]
+}
+
+// Trashed reports whether the item with the given ID was moved to the trash.
+func (s *Server) Trashed(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.trashed[id]
+}
+
+// Redirect makes the app redirect from path to target, e.g. to simulate a
+// logged out session, until it is called again with an empty target.
+func (s *Server) Redirect(path, target string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if target == "" {
+		delete(s.redirects, path)
+		return
+	}
+	s.redirects[path] = target
+}
+
+// PhotoURL returns the URL of the item with the given ID in the viewer.
+func (s *Server) PhotoURL(id string) string {
+	return s.URL + "/photo/" + id
+}
+
+func (s *Server) serveDownload(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/download/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, it := range s.items {
+		if it.ID == id {
+			s.downloads[id]++
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", it.Filename))
+			w.Write(it.data())
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// serveTrash removes the item from the library, as the confirmation of its
+// move to the trash does.
+func (s *Server) serveTrash(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/trash/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, it := range s.items {
+		if it.ID == id {
+			s.items = append(s.items[:i:i], s.items[i+1:]...)
+			s.trashed[id] = true
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// appItem is an item, as given to the JavaScript of the app.
+type appItem struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Label    string `json:"label"`
+}
+
+func (s *Server) serveApp(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	if target, ok := s.redirects[r.URL.Path]; ok {
+		s.mu.Unlock()
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+	// most recent first, as in the timeline.
+	var items []appItem
+	for i := len(s.items) - 1; i >= 0; i-- {
+		it := s.items[i]
+		items = append(items, appItem{
+			ID:       it.ID,
+			Filename: it.Filename,
+			Label:    "Photo - " + it.Taken.Format("Jan 2, 2006"),
+		})
+	}
+	s.mu.Unlock()
+	data, err := json.Marshal(items)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	appTemplate.Execute(w, template.JS(data))
+}
+
+// appTemplate is the single page of the app, which renders itself according
+// to its location.
+var appTemplate = template.Must(template.New("app").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Fake Photos</title></head>
+<body>
+<div id="app"></div>
+<script>
+const items = {{.}};
+
+function viewed() {
+	const m = location.pathname.match(/\/photo\/([^/]+)$/);
+	return m === null ? -1 : items.findIndex(it => it.id === m[1]);
+}
+
+function view(i) {
+	history.pushState(null, "", "/photo/" + items[i].id);
+	render();
+}
+
+function render() {
+	const app = document.getElementById("app");
+	const i = viewed();
+	if (i >= 0) {
+		app.innerHTML = "";
+		const h = document.createElement("h1");
+		h.innerText = items[i].filename;
+		app.appendChild(h);
+		// what the viewer shows of the item.
+		const img = document.createElement("img");
+		img.src = "data:image/gif;base64,R0lGODlhAQABAIAAAP///wAAACwAAAAAAQABAAACAkQBADs=";
+		img.style.width = "80vw";
+		img.style.height = "80vh";
+		app.appendChild(img);
+		return;
+	}
+	switch (location.pathname) {
+	case "/quotamanagement":
+		app.innerText = "Storage\n1 GB of 15 GB used\nGoogle Photos\n512 MB";
+		return;
+	case "/lockedfolder":
+		app.innerText = "Locked Folder";
+		return;
+	case "/photos/about/":
+		app.innerText = "Sign in to Google Photos";
+		return;
+	}
+	app.innerHTML = "";
+	for (const it of items) {
+		const a = document.createElement("a");
+		a.setAttribute("href", "./photo/" + it.id);
+		a.setAttribute("aria-label", it.label);
+		a.innerText = it.filename;
+		app.appendChild(a);
+		app.appendChild(document.createElement("br"));
+	}
+}
+
+document.addEventListener("keydown", (e) => {
+	const i = viewed();
+	if (i >= 0) {
+		if (e.key === "ArrowLeft" && i > 0) {
+			view(i - 1);
+		} else if (e.key === "ArrowRight" && i < items.length - 1) {
+			view(i + 1);
+		} else if (e.key === "D" && e.shiftKey) {
+			const a = document.createElement("a");
+			a.href = "/download/" + items[i].id;
+			a.download = items[i].filename;
+			document.body.appendChild(a);
+			a.click();
+			a.remove();
+		} else if (e.key === "#" && e.shiftKey) {
+			confirmTrash(i);
+		}
+		return;
+	}
+	const links = Array.from(document.querySelectorAll('a[href^="./photo/"]'));
+	const k = links.indexOf(document.activeElement);
+	if (e.key === "ArrowRight" && links.length > 0) {
+		links[Math.min(k + 1, links.length - 1)].focus();
+		e.preventDefault();
+	} else if (e.key === "Enter" && k >= 0) {
+		view(k);
+		e.preventDefault();
+	}
+});
+// confirmTrash shows the confirmation dialog of the move to the trash of the
+// i-th item, which then views the next one.
+function confirmTrash(i) {
+	const d = document.createElement("div");
+	d.setAttribute("role", "dialog");
+	d.innerText = "Move to trash?";
+	const b = document.createElement("button");
+	b.innerText = "Move to trash";
+	b.addEventListener("click", async () => {
+		await fetch("/trash/" + items[i].id, {method: "POST"});
+		d.remove();
+		items.splice(i, 1);
+		if (items.length === 0) {
+			history.pushState(null, "", "/");
+			render();
+			return;
+		}
+		view(Math.min(i, items.length - 1));
+	});
+	d.appendChild(b);
+	document.body.appendChild(d);
+}
+
+window.addEventListener("popstate", render);
+render();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// authCookies are the Google cookies that hold the authenticated session.
+var authCookies = map[string]bool{
+	"SID":              true,
+	"__Secure-1PSID":   true,
+	"__Secure-3PSID":   true,
+	"HSID":             true,
+	"SSID":             true,
+	"__Secure-1PSIDTS": true,
+}
+
+// reauthWarning is how long before the session expires we start warning
+// about it.
+const reauthWarning = 7 * 24 * time.Hour
+
+// keepAlive loads Google Photos, so Google refreshes the session cookies, and
+// warns if the session expired, or will soon, once for each expiry.
+func (s *Session) keepAlive(ctx context.Context) error {
+	if *verboseFlag {
+		log.Printf("Refreshing session of %v", s.dlDir)
+	}
+	var location string
+	if err := chromedp.Run(ctx,
+		chromedp.Navigate(s.photosURL),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+		chromedp.Location(&location),
+	); err != nil {
+		return err
+	}
+	// when logged out, we land on the about page, or on the re-login
+	// interstitial, as in login.
+	if !strings.HasPrefix(location, s.photosURL) {
+		if s.expiredNotified {
+			return nil
+		}
+		log.Printf("The session in %v expired, re-authentication is required before the next sync", s.profileDir)
+		s.notify(eventAuth, fmt.Errorf("the session in %v expired", s.profileDir))
+		s.expiredNotified = true
+		return nil
+	}
+	s.expiredNotified = false
+	var cookies []*network.Cookie
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		cookies, err = network.GetAllCookies().Do(ctx)
+		return err
+	})); err != nil {
+		return err
+	}
+	var expires time.Time
+	for _, c := range cookies {
+		if !authCookies[c.Name] || !strings.HasSuffix(c.Domain, "google.com") || c.Session {
+			continue
+		}
+		t := time.Unix(int64(c.Expires), 0)
+		if expires.IsZero() || t.Before(expires) {
+			expires = t
+		}
+	}
+	if !expires.IsZero() && time.Until(expires) < reauthWarning && !expires.Equal(s.expiryWarned) {
+		s.expiryWarned = expires
+		log.Printf("The session in %v expires at %v, re-authentication will soon be required", s.profileDir, expires.Format(time.RFC3339))
+		s.notify(eventAuth, fmt.Errorf("the session in %v expires at %v", s.profileDir, expires.Format(time.RFC3339)))
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+)
+
+// focusCheck is what keyEvent verifies about the keyboard focus.
+type focusCheck int
+
+const (
+	// focusAny does not check anything, e.g. for Escape, which can close
+	// what has the focus.
+	focusAny focusCheck = iota
+	// focusKept checks, before sending the key, that the focus was not
+	// taken by a dialog, a menu, or a text field, which would swallow it.
+	focusKept
+	// focusItem also checks, after sending the key, that the focus is on an
+	// item of the grid, and sends the key again once otherwise.
+	focusItem
+)
+
+// focusStateJS returns "item" if the focus is on an item of the grid,
+// "lost" if it is on a text field, or in a dialog or a menu, and "ok"
+// otherwise.
+const focusStateJS = `(function() {
+	const el = document.activeElement;
+	if (!el || el === document.body) {
+		return "ok";
+	}
+	if (el.matches('a[href*="/photo/"]')) {
+		return "item";
+	}
+	if (el.matches('input, textarea, [contenteditable="true"]')) {
+		return "lost";
+	}
+	const c = el.closest('[role="dialog"], [role="alertdialog"], [aria-modal="true"], [role="menu"]');
+	if (c) {
+		const r = c.getBoundingClientRect();
+		// but not the viewer, which is a full-screen dialog.
+		if (r.width < 0.9 * window.innerWidth || r.height < 0.9 * window.innerHeight) {
+			return "lost";
+		}
+	}
+	return "ok";
+})()`
+
+// keyEvent sends key to the page, as chromedp.KeyEvent does, with the focus
+// verified according to check.
+func keyEvent(ctx context.Context, key string, check focusCheck) error {
+	return withFocus(ctx, check, func() error {
+		return chromedp.KeyEvent(key).Do(ctx)
+	})
+}
+
+// withFocus runs send, which sends key events, with the focus verified
+// according to check, and given back to the page with refocus if needed.
+func withFocus(ctx context.Context, check focusCheck, send func() error) error {
+	if check == focusAny {
+		return send()
+	}
+	var state string
+	if err := chromedp.Evaluate(focusStateJS, &state).Do(ctx); err != nil {
+		return err
+	}
+	if state == "lost" {
+		if err := refocus(ctx); err != nil {
+			return err
+		}
+	}
+	if err := send(); err != nil {
+		return err
+	}
+	if check != focusItem {
+		return nil
+	}
+	if err := chromedp.Evaluate(focusStateJS, &state).Do(ctx); err != nil {
+		return err
+	}
+	if state == "item" {
+		return nil
+	}
+	if err := refocus(ctx); err != nil {
+		return err
+	}
+	return send()
+}
+
+// refocus gives the keyboard focus back to the page: it closes the dialogs,
+// and, on the grid, clicks next to its first item. In the viewer, where a
+// click could start a video, the focused element is blurred instead, which
+// gives the focus back to the document.
+func refocus(ctx context.Context) error {
+	counters.Add("refocus", 1)
+	if *verboseFlag {
+		log.Printf("Keyboard focus lost, getting it back")
+	}
+	if err := dismissDialogs(ctx); err != nil {
+		return err
+	}
+	var location string
+	if err := chromedp.Location(&location).Do(ctx); err != nil {
+		return err
+	}
+	if strings.Contains(location, "/photo/") {
+		var res []byte
+		return chromedp.Evaluate(`document.activeElement && document.activeElement.blur()`, &res).Do(ctx)
+	}
+	return focusGrid(ctx)
+}
+
+// keyPress sends the key down and key up events for the key r, with the given
+// modifiers, to the page.
+func keyPress(ctx context.Context, r rune, modifiers input.Modifier) error {
+	key, ok := kb.Keys[r]
+	if !ok {
+		return fmt.Errorf("no %q key", r)
+	}
+	down := input.DispatchKeyEventParams{
+		Key:                   key.Key,
+		Code:                  key.Code,
+		NativeVirtualKeyCode:  nativeKeyCode(key),
+		WindowsVirtualKeyCode: key.Windows,
+		Type:                  input.KeyDown,
+		Modifiers:             modifiers,
+	}
+	up := down
+	up.Type = input.KeyUp
+	defer traceStep("key "+string(r), "")()
+	rec.action("key", fmt.Sprintf("%c (modifiers %d)", r, modifiers))
+
+	return withFocus(ctx, focusKept, func() error {
+		for _, ev := range []*input.DispatchKeyEventParams{&down, &up} {
+			if *verboseFlag {
+				log.Printf("Event: %+v", *ev)
+			}
+			if err := ev.Do(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,27 @@
+//go:build !darwin
+// +build !darwin
+
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "github.com/chromedp/chromedp/kb"
+
+// nativeKeyCode returns the native virtual key code to send for key.
+func nativeKeyCode(key *kb.Key) int64 {
+	return key.Native
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ledgerFile is the name of the file, in the state directory, where we record every item we
+// download, one JSON object per line. Unlike .lastdone, which only says how
+// far we got, it says what we got.
+const ledgerFile = ".ledger"
+
+// ledgerEntry is a line of the ledger.
+type ledgerEntry struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Files are the paths, relative to dlDir, of the files of the item.
+	Files []string `json:"files"`
+	// Names are the names the browser gave to the downloads of the files,
+	// and Original the name of the item in the info panel, with -metadata.
+	Names    []string `json:"names,omitempty"`
+	Original string   `json:"original,omitempty"`
+	// Kind is the kind of the item, e.g. photo, or creation.
+	Kind string    `json:"kind,omitempty"`
+	Size int64     `json:"size"`
+	Time time.Time `json:"time"`
+}
+
+// appendLedger records e at the end of the ledger.
+func (s *Session) appendLedger(e ledgerEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.stateDir, ledgerFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if s.downloaded != nil {
+		s.downloaded[e.ID] = true
+	}
+	return nil
+}
+
+// saveLedger replaces the ledger in stateDir with entries.
+func saveLedger(stateDir string, entries []ledgerEntry) error {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	path := filepath.Join(stateDir, ledgerFile)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// loadDownloaded sets s.downloaded from the ledger.
+func (s *Session) loadDownloaded() error {
+	entries, err := loadLedger(s.stateDir)
+	if err != nil {
+		return err
+	}
+	s.downloaded = make(map[string]bool, len(entries))
+	for _, e := range entries {
+		s.downloaded[e.ID] = true
+	}
+	return nil
+}
+
+// inLedger reports whether the item at location was already downloaded,
+// according to the ledger.
+func (s *Session) inLedger(location string) bool {
+	id, err := itemID(location)
+	if err != nil {
+		return false
+	}
+	return s.downloaded[id]
+}
+
+// loadLedger returns the entries of the ledger in stateDir, in the order they
+// were recorded. When an item was downloaded several times, only its most
+// recent entry is returned, at the position of the first one.
+func loadLedger(stateDir string) ([]ledgerEntry, error) {
+	f, err := os.Open(filepath.Join(stateDir, ledgerFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []ledgerEntry
+	index := make(map[string]int)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e ledgerEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%v:%d: %v", ledgerFile, line, err)
+		}
+		if i, ok := index[e.ID]; ok {
+			entries[i] = e
+			continue
+		}
+		index[e.ID] = len(entries)
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/systeminfo"
+	"github.com/chromedp/chromedp"
+)
+
+// counters are the loop counters published in /debug/vars.
+var counters = expvar.NewMap("counters")
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("sessions", expvar.Func(func() interface{} {
+		var vars []sessionVars
+		for _, s := range sessions {
+			vars = append(vars, s.vars())
+		}
+		return vars
+	}))
+	http.HandleFunc("/screenshot", serveScreenshot)
+	http.HandleFunc("/status", serveStatus)
+}
+
+// serve starts the HTTP server for -listen, with, on top of whatever else is
+// registered on http.DefaultServeMux, the /debug/pprof and /debug/vars
+// diagnostics, the /status of the sessions, and the /screenshot of the page.
+func serve(addr string) {
+	log.Printf("Listening on %v", addr)
+	go func() {
+		log.Fatal(http.ListenAndServe(addr, nil))
+	}()
+}
+
+// serveScreenshot serves a PNG screenshot of the whole page of the browser
+// of the session of the account in the account parameter, or of the first
+// session.
+func serveScreenshot(w http.ResponseWriter, r *http.Request) {
+	account := r.FormValue("account")
+	var s *Session
+	for _, ss := range sessions {
+		if account == "" || ss.account == account {
+			s = ss
+			break
+		}
+	}
+	if s == nil {
+		http.Error(w, "no such account", http.StatusNotFound)
+		return
+	}
+	if s.ctx == nil || chromedp.FromContext(s.ctx).Target == nil {
+		http.Error(w, "browser not running", http.StatusServiceUnavailable)
+		return
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+	var scr []byte
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		scr, err = fullScreenshot(ctx)
+		return err
+	})); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(scr)
+}
+
+// sessionVars is the snapshot of a session published in /debug/vars.
+type sessionVars struct {
+	Account    string          `json:"account,omitempty"`
+	DlDir      string          `json:"dlDir"`
+	Item       string          `json:"item,omitempty"`
+	State      string          `json:"state,omitempty"`
+	Downloaded int             `json:"downloaded"`
+	Remaining  *int            `json:"remaining,omitempty"`
+	Chrome     []chromeProcess `json:"chrome,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// chromeProcess is a process of the browser.
+type chromeProcess struct {
+	PID     int64   `json:"pid"`
+	Type    string  `json:"type"`
+	CPUTime float64 `json:"cpuTime"`
+	// RSS is its resident memory, in bytes, where we can tell.
+	RSS int64 `json:"rss,omitempty"`
+}
+
+// status returns the snapshot of s for /status, without the browser
+// processes.
+func (s *Session) status() sessionVars {
+	// the heartbeat, rather than the summary, which is not safe to read
+	// from another goroutine.
+	beat := s.heartbeat()
+	v := sessionVars{
+		Account:    s.account,
+		DlDir:      s.dlDir,
+		Item:       beat.Item,
+		State:      beat.State,
+		Downloaded: beat.Done,
+	}
+	if remaining, ok := s.remaining(); ok {
+		v.Remaining = &remaining
+	}
+	return v
+}
+
+// serveStatus serves, as JSON, the status of all the sessions.
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	var vars []sessionVars
+	for _, s := range sessions {
+		vars = append(vars, s.status())
+	}
+	data, err := json.MarshalIndent(vars, "", "\t")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// vars returns the snapshot of s for /debug/vars.
+func (s *Session) vars() sessionVars {
+	v := s.status()
+	if s.ctx == nil || chromedp.FromContext(s.ctx).Browser == nil {
+		return v
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+	// a browser, not a target, command.
+	ctx = cdp.WithExecutor(ctx, chromedp.FromContext(ctx).Browser)
+	procs, err := systeminfo.GetProcessInfo().Do(ctx)
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	for _, p := range procs {
+		v.Chrome = append(v.Chrome, chromeProcess{
+			PID:     p.ID,
+			Type:    p.Type,
+			CPUTime: p.CPUTime,
+			RSS:     processRSS(p.ID),
+		})
+	}
+	return v
+}
+
+// processRSS returns the resident memory of the process with the given PID,
+// or 0 if it cannot tell, which is the case where there is no /proc.
+func processRSS(pid int64) int64 {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 3 && fields[0] == "VmRSS:" && fields[2] == "kB" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb << 10
+		}
+	}
+	return 0
+}
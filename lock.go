@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const lockFile = ".lock"
+
+// lockDlDir acquires the lock on s.dlDir, so that no two instances ever run
+// against the same download directory. The lock is an advisory lock on the
+// dlDir/.lock file, which the system releases when its owner ends, even if it
+// crashed, except on the platforms without such locks, where a crashed owner
+// leaves the lock behind. The file contains the PID of the owner, for the
+// error message.
+func (s *Session) lockDlDir() error {
+	path := filepath.Join(s.dlDir, lockFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	ok, err := tryLock(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not acquire lock %v: %v", path, err)
+	}
+	if !ok {
+		// tryLock may have read the file already.
+		var data []byte
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			data, _ = ioutil.ReadAll(f)
+		}
+		f.Close()
+		return fmt.Errorf("%v is already in use by process %v (see %v%v)", s.dlDir, strings.TrimSpace(string(data)), path, staleLockHint)
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return err
+	}
+	s.lock = f
+	return nil
+}
+
+// unlockDlDir releases the lock on s.dlDir, if we hold it. The file stays, as
+// removing it would race with another instance opening it.
+func (s *Session) unlockDlDir() {
+	if s.lock == nil {
+		return
+	}
+	if err := s.lock.Truncate(0); err != nil {
+		log.Printf("Error releasing lock on %v: %v", s.dlDir, err)
+	}
+	if err := s.lock.Close(); err != nil {
+		log.Printf("Error releasing lock on %v: %v", s.dlDir, err)
+	}
+	s.lock = nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// The states of the Locked Folder, as recorded in the run summary.
+const (
+	lockedFolderNone   = "none"   // not set up
+	lockedFolderEmpty  = "empty"  // set up, but without items
+	lockedFolderItems  = "items"  // with items
+	lockedFolderLocked = "locked" // needs the user to verify their identity
+)
+
+// lockedFolderStateJS returns the state of the Locked Folder page, except
+// for lockedFolderItems, which is told by the items of the grid.
+const lockedFolderStateJS = `(function() {
+	if (location.hostname === "accounts.google.com") {
+		return "locked";
+	}
+	if (/set up locked folder|get started/i.test(document.body.innerText)) {
+		return "none";
+	}
+	return "empty";
+})()`
+
+// checkLockedFolder finds out whether the Locked Folder has items, which never
+// appear in the timeline, and warns about them in the run summary, unless
+// -lockedfolder is set, in which case it downloads them.
+func (s *Session) checkLockedFolder(ctx context.Context) error {
+	var state string
+	var items []gridItem
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		if err := navigate(ctx, strings.TrimSuffix(s.photosURL, "/")+"/lockedfolder"); err != nil {
+			return err
+		}
+		// the grid fills itself asynchronously.
+		time.Sleep(2 * tick)
+		if err := chromedp.Evaluate(lockedFolderStateJS, &state).Do(ctx); err != nil {
+			return err
+		}
+		if state != lockedFolderEmpty {
+			return nil
+		}
+		var err error
+		items, err = scrollCollect(ctx, gridItemsJS)
+		return err
+	})); err != nil {
+		return err
+	}
+	if len(items) > 0 {
+		state = lockedFolderItems
+	}
+	s.summary.LockedFolder = state
+
+	switch {
+	case state == lockedFolderLocked:
+		s.summary.warn("the Locked Folder could not be opened without verifying your identity, its items, if any, are not backed up")
+	case state == lockedFolderItems && !*lockedFolderFlag:
+		s.summary.warn(fmt.Sprintf("the Locked Folder has %d items, which are not backed up without -lockedfolder", len(items)))
+	case state == lockedFolderItems:
+		for _, it := range items {
+			if s.inLedger(it.Href) {
+				continue
+			}
+			if *dryRunFlag {
+				log.Printf("Would download %v, from the Locked Folder", it.Href)
+				continue
+			}
+			if err := s.retryItem(ctx, it.Href); err != nil {
+				if !isItemError(err) {
+					return err
+				}
+				log.Printf("Error downloading %v from the Locked Folder: %v", it.Href, err)
+				s.summary.addFailure(it.Href, err)
+				s.printItem(it.Href, actionFailed, nil, 0, err)
+			}
+		}
+	}
+	return nil
+}
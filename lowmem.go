@@ -0,0 +1,38 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/chromedp/chromedp"
+)
+
+// lowMemReloadEvery is the number of items after which, with -lowmem, the
+// viewer is reloaded, to free the memory the page accumulates over time.
+const lowMemReloadEvery = 100
+
+// lowMemOptions returns the Chrome options of -lowmem: a small window, all
+// the sites in the same renderer process, and a capped JavaScript heap.
+func lowMemOptions() []chromedp.ExecAllocatorOption {
+	return []chromedp.ExecAllocatorOption{
+		chromedp.WindowSize(1024, 768),
+		chromedp.Flag("disable-features", "site-per-process,IsolateOrigins,TranslateUI,BlinkGenPropertyTrees"),
+		chromedp.Flag("disable-site-isolation-trials", true),
+		chromedp.Flag("renderer-process-limit", "1"),
+		chromedp.Flag("js-flags", "--max-old-space-size=256"),
+		chromedp.Flag("disk-cache-size", "1"),
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "512", want: 512},
+		{in: "10B", want: 10},
+		{in: "1KB", want: 1 << 10},
+		{in: "1.5 MB", want: 3 << 19},
+		{in: "2gb", want: 2 << 30},
+		{in: " 1TB ", want: 1 << 40},
+		{in: "", wantErr: true},
+		{in: "MB", wantErr: true},
+		{in: "-1MB", wantErr: true},
+		{in: "1XB", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSize(%q) error = %v, want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "100KB/s", want: 100 << 10},
+		{in: "1MBps", want: 1 << 20},
+		{in: "2MB", want: 2 << 20},
+		{in: "0KB/s", wantErr: true},
+		{in: "fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseRate(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRate(%q) error = %v, want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseRate(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestItemID(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "https://photos.google.com/photo/AF1QipABC", want: "AF1QipABC"},
+		{in: "https://photos.google.com/photo/AF1QipABC/more", want: "AF1QipABC"},
+		{in: "https://photos.google.com/u/1/photo/AF1QipABC", want: "AF1QipABC"},
+		{in: "https://photos.google.com/share/AF1QipAlbum/photo/AF1QipABC?key=K", want: "AF1QipABC"},
+		{in: "https://photos.google.com/", wantErr: true},
+		{in: "AF1QipABC", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := itemID(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("itemID(%q) error = %v, want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("itemID(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
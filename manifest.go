@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The actions of the records written with -print.
+const (
+	actionDownloaded = "downloaded" // stored, with its files
+	actionKnown      = "known"      // already in the ledger
+	actionSkipped    = "skipped"    // in the -skip list
+	actionDeferred   = "deferred"   // not selected by the filters, e.g. -only
+	actionFailed     = "failed"     // could not be downloaded, see the error
+	actionDryRun     = "dryrun"     // would be downloaded, with -dryrun
+)
+
+// manifestRecord is the record written, with -print, for each processed item.
+// Its fields, and the columns of the CSV form, are a stable contract for the
+// programs that consume them.
+type manifestRecord struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	// Path is the path of the first file of the item, and Files the paths
+	// of all of them, when downloaded.
+	Path  string   `json:"path,omitempty"`
+	Files []string `json:"files,omitempty"`
+	Bytes int64    `json:"bytes,omitempty"`
+	// Duration is how long, in seconds, processing the item took, if it
+	// was downloaded or attempted.
+	Duration float64 `json:"duration,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// manifestColumns are the columns of the records with -print csv.
+var manifestColumns = []string{"id", "action", "path", "bytes", "duration", "error"}
+
+// userOutput is where to print the messages for the user, which must not mix
+// with the records of -print on stdout.
+func userOutput() io.Writer {
+	if *printFlag != "" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// startItem records that the processing of the item at location begins now,
+// for the duration of its -print record.
+func (s *Session) startItem(location string) {
+	if *printFlag == "" {
+		return
+	}
+	s.muPrint.Lock()
+	defer s.muPrint.Unlock()
+	if s.itemStarts == nil {
+		s.itemStarts = make(map[string]time.Time)
+	}
+	s.itemStarts[location] = time.Now()
+}
+
+// printItem writes, with -print, the record of the item at location, to
+// which action was applied, with the paths of its files and their size in
+// bytes, if any, and the error that made it fail, or deferred it.
+func (s *Session) printItem(location, action string, paths []string, size int64, err error) {
+	if *printFlag == "" {
+		return
+	}
+	id, _ := itemID(location)
+	r := manifestRecord{ID: id, Action: action, Files: paths, Bytes: size}
+	if len(paths) > 0 {
+		r.Path = paths[0]
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	s.muPrint.Lock()
+	defer s.muPrint.Unlock()
+	if start, ok := s.itemStarts[location]; ok {
+		r.Duration = time.Since(start).Seconds()
+		delete(s.itemStarts, location)
+	}
+	if *printFlag == "jsonl" {
+		data, err := json.Marshal(r)
+		if err == nil {
+			_, err = os.Stdout.Write(append(data, '\n'))
+		}
+		if err != nil {
+			log.Printf("Error printing the record of %v: %v", location, err)
+		}
+		return
+	}
+	w := csv.NewWriter(os.Stdout)
+	if !s.printedHeader {
+		w.Write(manifestColumns)
+		s.printedHeader = true
+	}
+	w.Write([]string{r.ID, r.Action, r.Path, strconv.FormatInt(r.Bytes, 10), strconv.FormatFloat(r.Duration, 'f', 3, 64), r.Error})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("Error printing the record of %v: %v", location, err)
+	}
+}
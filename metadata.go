@@ -0,0 +1,235 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// metadataFile is the name of the sidecar file written in each item directory
+// with -metadata.
+const metadataFile = "metadata.json"
+
+// itemInfo is the metadata of an item, as shown in the info panel of the
+// viewer, and written to its sidecar file.
+type itemInfo struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Kind is the kind of the item: photo, video, motion (photo), or
+	// creation.
+	Kind string `json:"kind,omitempty"`
+	// Filename is the name of the original file, and Size its size in
+	// bytes, if known.
+	Filename string `json:"filename,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	// Taken is when the item was taken, if known.
+	Taken time.Time `json:"taken,omitempty"`
+	// Description is the description of the item, if any.
+	Description string `json:"description,omitempty"`
+	// Latitude and Longitude are where the item was taken, if HasLocation.
+	HasLocation bool    `json:"hasLocation,omitempty"`
+	Latitude    float64 `json:"latitude,omitempty"`
+	Longitude   float64 `json:"longitude,omitempty"`
+	// People are the names of the people recognized in the item.
+	People []string `json:"people,omitempty"`
+	// Quality is the storage quality of the item: "original", "storage saver",
+	// or "express". It is empty if it could not be determined.
+	Quality string `json:"quality,omitempty"`
+	// Details is the raw text of the info panel.
+	Details []string `json:"details,omitempty"`
+	// Files are the names of the files stored for the item.
+	Files []string `json:"files,omitempty"`
+	// Stack are the IDs of the other members of the stack of the item, with
+	// -stacks.
+	Stack []string `json:"stack,omitempty"`
+	// MotionPhotos link the still images and the videos of the motion
+	// photos, with -motion.
+	MotionPhotos []motionPair `json:"motionPhotos,omitempty"`
+}
+
+// infoPanelJS returns the text of the info panel of the viewer, if it is
+// opened, or the empty string otherwise. The panel is the smallest visible
+// element whose text starts with the "Info" title.
+const infoPanelJS = `(function() {
+	let panel = null;
+	for (const el of document.querySelectorAll("c-wiz div, aside, [role='complementary']")) {
+		const r = el.getBoundingClientRect();
+		if (r.width === 0 || r.height === 0) {
+			continue;
+		}
+		if (!el.innerText || !el.innerText.trim().startsWith("Info\n")) {
+			continue;
+		}
+		if (panel === null || panel.contains(el)) {
+			panel = el;
+		}
+	}
+	return panel === null ? "" : panel.innerText;
+})()`
+
+// descriptionJS returns the description of the currently viewed item, from
+// the text area of the info panel.
+const descriptionJS = `(function() {
+	const el = document.querySelector('textarea[aria-label*="description" i]');
+	return el === null ? "" : el.value;
+})()`
+
+// infoPanelText returns the lines of the info panel of the currently viewed
+// item, opening the panel first if needed. The panel then stays open across
+// items.
+func infoPanelText(ctx context.Context) ([]string, error) {
+	var text string
+	var b backoff
+	deadline := time.Now().Add(10 * tick)
+	for i := 0; ; i++ {
+		if err := chromedp.Evaluate(infoPanelJS, &text).Do(ctx); err != nil {
+			return nil, err
+		}
+		if text != "" || time.Now().After(deadline) {
+			break
+		}
+		if i == 0 {
+			// toggles the info panel
+			if err := keyEvent(ctx, "i", focusKept); err != nil {
+				return nil, err
+			}
+		}
+		b.sleep()
+	}
+	var lines []string
+	for _, l := range strings.Split(text, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines, nil
+}
+
+var (
+	storageSaverRx = regexp.MustCompile(`(?i)\b(storage saver|high quality)\b`)
+	expressRx      = regexp.MustCompile(`(?i)\bexpress\b`)
+	originalRx     = regexp.MustCompile(`(?i)\boriginal( quality)?\b`)
+	filenameRx     = regexp.MustCompile(`^[^\s/\\]+\.[A-Za-z][A-Za-z0-9]{1,4}$`)
+	fileSizeRx     = regexp.MustCompile(`\b(\d+(?:\.\d+)?\s?[KMG]B)\b`)
+	takenTimeRx    = regexp.MustCompile(`^[A-Z][a-z]{2}, (\d{1,2}:\d{2}\s?[AP]M)`)
+	takenZoneRx    = regexp.MustCompile(`^GMT([+-]\d{2}:\d{2})$`)
+)
+
+// parseTaken returns when the item was taken, from the lines of the info
+// panel: a date (e.g. "Sep 13, 2020"), and optionally a time (e.g. "Sun, 2:26
+// PM") and a time zone (e.g. "GMT+02:00"). It returns the zero time if
+// there is no date.
+func parseTaken(lines []string) time.Time {
+	var date, clock, zone string
+	for _, l := range lines {
+		if _, err := time.Parse("Jan 2, 2006", l); err == nil && date == "" {
+			date = l
+		} else if m := takenTimeRx.FindStringSubmatch(l); m != nil && clock == "" {
+			clock = strings.Replace(m[1], " ", "", 1)
+		} else if m := takenZoneRx.FindStringSubmatch(l); m != nil && zone == "" {
+			zone = m[1]
+		}
+	}
+	if date == "" {
+		return time.Time{}
+	}
+	if clock == "" {
+		clock = "12:00AM"
+	}
+	if zone == "" {
+		zone = "+00:00"
+	}
+	t, err := time.Parse("Jan 2, 2006 3:04PM -07:00", date+" "+clock+" "+zone)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// readItemInfo returns the metadata of the currently viewed item, found at
+// location.
+func readItemInfo(ctx context.Context, location string) (*itemInfo, error) {
+	defer traceStep("info panel", location)()
+	id, err := itemID(location)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := infoPanelText(ctx)
+	if err != nil {
+		return nil, err
+	}
+	info := &itemInfo{
+		ID:      id,
+		URL:     location,
+		Taken:   parseTaken(lines),
+		Details: lines,
+	}
+	if err := chromedp.Evaluate(descriptionJS, &info.Description).Do(ctx); err != nil {
+		return nil, err
+	}
+	info.Description = strings.TrimSpace(info.Description)
+	var latLng string
+	if err := chromedp.Evaluate(infoLocationJS, &latLng).Do(ctx); err != nil {
+		return nil, err
+	}
+	info.Latitude, info.Longitude, info.HasLocation = parseLatLng(latLng)
+	if err := chromedp.Evaluate(peopleJS, &info.People).Do(ctx); err != nil {
+		return nil, err
+	}
+	for _, l := range lines {
+		switch {
+		case storageSaverRx.MatchString(l):
+			info.Quality = "storage saver"
+		case expressRx.MatchString(l):
+			info.Quality = "express"
+		case originalRx.MatchString(l) && info.Quality == "":
+			info.Quality = "original"
+		}
+		if info.Filename == "" && filenameRx.MatchString(l) {
+			info.Filename = l
+		}
+		if m := fileSizeRx.FindStringSubmatch(l); m != nil && info.Size == 0 {
+			if size, err := parseSize(m[1]); err == nil {
+				info.Size = size
+			}
+		}
+	}
+	return info, nil
+}
+
+// writeSidecar writes info as the metadata sidecar file in dir.
+func writeSidecar(dir string, info *itemInfo) error {
+	data, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, metadataFile)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
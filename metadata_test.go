@@ -0,0 +1,61 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTaken(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  time.Time
+	}{
+		{
+			name:  "no date",
+			lines: []string{"Info", "IMG_0001.JPG"},
+		},
+		{
+			name:  "date only",
+			lines: []string{"Info", "Sep 13, 2020"},
+			want:  time.Date(2020, 9, 13, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "date and time",
+			lines: []string{"Info", "Sep 13, 2020", "Sun, 2:26 PM"},
+			want:  time.Date(2020, 9, 13, 14, 26, 0, 0, time.UTC),
+		},
+		{
+			name:  "date, time, and zone",
+			lines: []string{"Info", "Sep 13, 2020", "Sun, 2:26 PM", "GMT+02:00", "IMG_0001.JPG"},
+			want:  time.Date(2020, 9, 13, 12, 26, 0, 0, time.UTC),
+		},
+		{
+			name:  "first date wins",
+			lines: []string{"Jan 2, 2019", "Sep 13, 2020"},
+			want:  time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		got := parseTaken(tt.lines)
+		if !got.Equal(tt.want) {
+			t.Errorf("%v: parseTaken = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// microVideoOffsetRx matches the XMP property of the older Motion Photo
+	// format (MVIMG), which is the offset of the video from the end of the file.
+	microVideoOffsetRx = regexp.MustCompile(`GCamera:MicroVideoOffset="(\d+)"`)
+	// motionPhotoLengthRx matches the XMP container item of the newer Motion
+	// Photo format, whose length is the one of the video at the end of the file.
+	motionPhotoLengthRx = regexp.MustCompile(`Item:Semantic="MotionPhoto"[^>]*?Item:Length="(\d+)"|Item:Length="(\d+)"[^>]*?Item:Semantic="MotionPhoto"`)
+)
+
+// motionVideoOffset returns the offset in data, which should be the contents
+// of a JPEG file, of the video embedded in it if it is a Motion Photo, or -1
+// otherwise.
+func motionVideoOffset(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		// not a JPEG
+		return -1
+	}
+	offset := -1
+	if m := microVideoOffsetRx.FindSubmatch(data); m != nil {
+		if n, err := strconv.Atoi(string(m[1])); err == nil && n > 0 && n < len(data) {
+			offset = len(data) - n
+		}
+	} else if m := motionPhotoLengthRx.FindSubmatch(data); m != nil {
+		l := m[1]
+		if len(l) == 0 {
+			l = m[2]
+		}
+		if n, err := strconv.Atoi(string(l)); err == nil && n > 0 && n < len(data) {
+			offset = len(data) - n
+		}
+	} else {
+		return -1
+	}
+	// the video is an MP4 file, whose first box is of type ftyp.
+	if offset >= 0 && offset+8 <= len(data) && bytes.Equal(data[offset+4:offset+8], []byte("ftyp")) {
+		return offset
+	}
+	// the offset in the metadata is sometimes off, so look for the ftyp box
+	// ourselves: the last one whose size is plausible.
+	end := len(data)
+	for {
+		i := bytes.LastIndex(data[:end], []byte("ftyp"))
+		if i < 4 {
+			return -1
+		}
+		size := binary.BigEndian.Uint32(data[i-4 : i])
+		if size >= 8 && size <= 256 {
+			return i - 4
+		}
+		end = i
+	}
+}
+
+// splitMotionPhoto extracts the video embedded in the Motion Photo at path,
+// to a file with the same name, but with the .mp4 extension, and strips it
+// from the still image. It returns the path of the video, or the empty string
+// if path is not a Motion Photo.
+func splitMotionPhoto(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	offset := motionVideoOffset(data)
+	if offset <= 0 {
+		return "", nil
+	}
+	videoPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".mp4"
+	if err := ioutil.WriteFile(videoPath, data[offset:], 0600); err != nil {
+		return "", err
+	}
+	// so an interruption never leaves a truncated still.
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data[:offset], 0600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return "", err
+	}
+	return videoPath, nil
+}
+
+// unzipItem extracts all the files of the zip archive at path in its
+// directory, removes the archive, and returns the paths of the extracted files.
+// The directories of the archive are flattened, and a file whose name is
+// already taken, in the archive or in the directory, gets a -N suffix.
+func unzipItem(path string) ([]string, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	dir := filepath.Dir(path)
+	var paths []string
+	taken := make(map[string]bool)
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		// flatten, and never write outside of dir.
+		name := filepath.Base(filepath.FromSlash(f.Name))
+		if name == "." || name == ".." || name == string(filepath.Separator) {
+			return nil, itemErrorf("invalid file name %q in %v", f.Name, path)
+		}
+		newPath := filepath.Join(dir, name)
+		for n := 1; ; n++ {
+			if _, err := os.Lstat(newPath); !taken[newPath] && os.IsNotExist(err) {
+				break
+			}
+			newPath = filepath.Join(dir, withSuffix(name, fmt.Sprintf("-%d", n)))
+		}
+		taken[newPath] = true
+		if err := extractZipFile(f, newPath); err != nil {
+			return nil, err
+		}
+		paths = append(paths, newPath)
+	}
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+func extractZipFile(f *zip.File, newPath string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	w, err := os.OpenFile(newPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// motionFiles post-processes, for -motion, the files downloaded for an item:
+// zip archives (as Google Photos serves e.g. Live Photos) are extracted, so
+// the paired files are kept together in the item directory, and the video
+// embedded in Motion Photos is split out to its own file. It returns the paths
+// of the resulting files, and the still image and video of each of the
+// motion photos found, e.g. of the original and of the edited version, with
+// -both. A still is paired with the video of the same name, or with the one
+// embedded in it, or else with the only video left unpaired, if it is the
+// only still left too.
+func motionFiles(paths []string) (newPaths []string, pairs []motionPair, err error) {
+	for _, path := range paths {
+		if strings.EqualFold(filepath.Ext(path), ".zip") {
+			extracted, err := unzipItem(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			newPaths = append(newPaths, extracted...)
+			continue
+		}
+		newPaths = append(newPaths, path)
+	}
+	stem := func(path string) string {
+		return strings.ToLower(strings.TrimSuffix(path, filepath.Ext(path)))
+	}
+	var stills []string
+	videos := make(map[string]string)
+	for _, path := range newPaths {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".jpg", ".jpeg", ".heic":
+			stills = append(stills, path)
+		case ".mp4", ".mov":
+			videos[stem(path)] = path
+		}
+	}
+	var unpaired []string
+	for _, still := range stills {
+		video, ok := videos[stem(still)]
+		if ok {
+			delete(videos, stem(still))
+		} else {
+			video, err = splitMotionPhoto(still)
+			if err != nil {
+				return nil, nil, err
+			}
+			if video == "" {
+				unpaired = append(unpaired, still)
+				continue
+			}
+			log.Printf("Extracted motion photo video %v", video)
+			newPaths = append(newPaths, video)
+		}
+		pairs = append(pairs, motionPair{Still: filepath.Base(still), Video: filepath.Base(video)})
+	}
+	if len(unpaired) == 1 && len(videos) == 1 {
+		for _, video := range videos {
+			pairs = append(pairs, motionPair{Still: filepath.Base(unpaired[0]), Video: filepath.Base(video)})
+		}
+	}
+	return newPaths, pairs, nil
+}
+
+// motionPair is the still image and the video of a motion photo, or of a
+// Live Photo.
+type motionPair struct {
+	Still string `json:"still"`
+	Video string `json:"video"`
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestMotionVideoOffset(t *testing.T) {
+	jpeg := []byte("\xFF\xD8\xFF\xE1 some exif ")
+	// an MP4 file starts with its ftyp box: its size, then its type.
+	video := []byte("\x00\x00\x00\x18ftypmp42 the video")
+	withXMP := func(xmp string) []byte {
+		return append(append(append([]byte{}, jpeg...), xmp...), video...)
+	}
+	n := strconv.Itoa(len(video))
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{
+			name: "not a JPEG",
+			data: append([]byte("PNG"), video...),
+			want: -1,
+		},
+		{
+			name: "no motion photo metadata",
+			data: withXMP("nothing"),
+			want: -1,
+		},
+		{
+			name: "MicroVideoOffset",
+			data: withXMP(`GCamera:MicroVideoOffset="` + n + `"`),
+			want: len(jpeg) + len(`GCamera:MicroVideoOffset="`+n+`"`),
+		},
+		{
+			name: "MotionPhoto Item:Length",
+			data: withXMP(`<Container:Item Item:Semantic="MotionPhoto" Item:Length="` + n + `"/>`),
+			want: len(jpeg) + len(`<Container:Item Item:Semantic="MotionPhoto" Item:Length="`+n+`"/>`),
+		},
+		{
+			name: "Item:Length before Item:Semantic",
+			data: withXMP(`<Container:Item Item:Length="` + n + `" Item:Semantic="MotionPhoto"/>`),
+			want: len(jpeg) + len(`<Container:Item Item:Length="`+n+`" Item:Semantic="MotionPhoto"/>`),
+		},
+		{
+			name: "wrong offset, found from the ftyp box",
+			data: withXMP(`GCamera:MicroVideoOffset="` + strconv.Itoa(len(video)+3) + `"`),
+			want: len(jpeg) + len(`GCamera:MicroVideoOffset="`+strconv.Itoa(len(video)+3)+`"`),
+		},
+		{
+			name: "no video",
+			data: append(append([]byte{}, jpeg...), `GCamera:MicroVideoOffset="5" no video`...),
+			want: -1,
+		},
+	}
+	for _, tt := range tests {
+		got := motionVideoOffset(tt.data)
+		if got != tt.want {
+			t.Errorf("%v: motionVideoOffset = %d, want %d", tt.name, got, tt.want)
+			continue
+		}
+		if got >= 0 && !bytes.Equal(tt.data[got:], video) {
+			t.Errorf("%v: motionVideoOffset = %d, which is not the start of the video", tt.name, got)
+		}
+	}
+}
+
+func TestMotionFiles(t *testing.T) {
+	motion := "\xFF\xD8 GCamera:MicroVideoOffset=\"22\" \x00\x00\x00\x18ftypmp42 the video"
+	zipOf := func(names ...string) string {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, name := range names {
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(name))
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	tests := []struct {
+		name      string
+		files     map[string]string
+		wantFiles []string
+		wantPairs []motionPair
+	}{
+		{
+			name:      "motion photos of -both",
+			files:     map[string]string{"a.jpg": motion, "a-edited.jpg": motion},
+			wantFiles: []string{"a-edited.jpg", "a-edited.mp4", "a.jpg", "a.mp4"},
+			wantPairs: []motionPair{{Still: "a-edited.jpg", Video: "a-edited.mp4"}, {Still: "a.jpg", Video: "a.mp4"}},
+		},
+		{
+			name:      "Live Photo",
+			files:     map[string]string{"live.zip": zipOf("IMG.HEIC", "IMG.MOV")},
+			wantFiles: []string{"IMG.HEIC", "IMG.MOV"},
+			wantPairs: []motionPair{{Still: "IMG.HEIC", Video: "IMG.MOV"}},
+		},
+		{
+			name:      "video of another name",
+			files:     map[string]string{"a.jpg": "\xFF\xD8 still", "b.mp4": "video"},
+			wantFiles: []string{"a.jpg", "b.mp4"},
+			wantPairs: []motionPair{{Still: "a.jpg", Video: "b.mp4"}},
+		},
+		{
+			name:      "same names in the archive",
+			files:     map[string]string{"a.zip": zipOf("x/IMG.HEIC", "y/IMG.HEIC")},
+			wantFiles: []string{"IMG-1.HEIC", "IMG.HEIC"},
+		},
+	}
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "motion")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		var paths []string
+		for name, data := range tt.files {
+			path := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		got, pairs, err := motionFiles(paths)
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		var files []string
+		for _, path := range got {
+			files = append(files, filepath.Base(path))
+		}
+		sort.Strings(files)
+		if !reflect.DeepEqual(files, tt.wantFiles) {
+			t.Errorf("%v: files = %q, want %q", tt.name, files, tt.wantFiles)
+		}
+		if !reflect.DeepEqual(pairs, tt.wantPairs) {
+			t.Errorf("%v: pairs = %v, want %v", tt.name, pairs, tt.wantPairs)
+		}
+	}
+}
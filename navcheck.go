@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// navTracker tracks the items seen while navigating the viewer, to detect,
+// against the list of the library written by -enumerate, the items the viewer
+// jumped over, which happens under load.
+type navTracker struct {
+	items []enumItem
+	// index is the position of each item in items.
+	index map[string]int
+	// prev is the position of the item seen last, or -1.
+	prev int
+	seen map[string]bool
+	// skipped are the items that were jumped over, in the order they were
+	// found, which can still be seen later, as items taken at the same time
+	// can be in a different order in the list.
+	skipped []enumItem
+}
+
+// newNavTracker returns a tracker against the items file, or nil if there
+// is none, or if the items are not navigated in the order they were taken.
+func (s *Session) newNavTracker() (*navTracker, error) {
+	if recentlyAdded() {
+		return nil, nil
+	}
+	f, err := os.Open(filepath.Join(s.dlDir, itemsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t := &navTracker{index: make(map[string]int), prev: -1, seen: make(map[string]bool)}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var it enumItem
+		if err := json.Unmarshal(sc.Bytes(), &it); err != nil {
+			return nil, err
+		}
+		t.index[it.ID] = len(t.items)
+		t.items = append(t.items, it)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// saw records that the item id was just seen. If the item before it in the
+// viewer is not adjacent to it in the list, the items in between are
+// recorded as skipped.
+func (t *navTracker) saw(id string) {
+	if t == nil {
+		return
+	}
+	t.seen[id] = true
+	cur, ok := t.index[id]
+	if !ok {
+		// added since the list was made.
+		t.prev = -1
+		return
+	}
+	prev := t.prev
+	t.prev = cur
+	if prev < 0 {
+		return
+	}
+	from, to := prev, cur
+	if from > to {
+		from, to = to, from
+	}
+	if to-from <= 1 {
+		return
+	}
+	for _, it := range t.items[from+1 : to] {
+		t.skipped = append(t.skipped, it)
+	}
+}
+
+// recoverSkipped downloads, each in a new tab, the items the viewer jumped
+// over, according to t, that were not seen afterwards, and are not
+// downloaded yet.
+func (s *Session) recoverSkipped(ctx context.Context, t *navTracker) error {
+	if t == nil {
+		return nil
+	}
+	for _, it := range t.skipped {
+		if t.seen[it.ID] || s.downloaded[it.ID] || s.skip[it.ID] {
+			continue
+		}
+		t.seen[it.ID] = true
+		counters.Add("navSkips", 1)
+		s.summary.NavSkipped++
+		if *dryRunFlag {
+			log.Printf("Would recover %v, jumped over by the viewer", it.URL)
+			continue
+		}
+		log.Printf("Recovering %v, jumped over by the viewer", it.URL)
+		if err := s.retryItem(ctx, it.URL); err != nil {
+			if !isItemError(err) {
+				return err
+			}
+			// maybe deleted since the list was made.
+			log.Printf("Could not recover %v: %v", it.URL, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Values of -order.
+const (
+	orderTaken         = "taken"
+	orderRecentlyAdded = "recently-added"
+)
+
+// recentlyAddedKnownStop is how many consecutive already downloaded items
+// end a crawl of the recently added items, as all the ones added before are
+// then most likely downloaded too.
+const recentlyAddedKnownStop = 50
+
+// recentlyAddedPath is the path, relative to photosURL, of the view of the
+// items in the order they were added to the library, most recent first.
+const recentlyAddedPath = "search/_tra_"
+
+// recentlyAdded reports whether we crawl the items in the order they were
+// added to the library, rather than in the order they were taken.
+func recentlyAdded() bool {
+	return *orderFlag == orderRecentlyAdded
+}
+
+// firstNavRecentlyAdded is firstNav for -order recently-added. It navigates to
+// the most recently added item, from the recently added view, so the viewer
+// then navigates through that view.
+func (s *Session) firstNavRecentlyAdded(ctx context.Context) error {
+	if err := navigate(ctx, strings.TrimSuffix(s.photosURL, "/")+"/"+recentlyAddedPath); err != nil {
+		return err
+	}
+	// the grid fills itself asynchronously.
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(tick) {
+		var items []gridItem
+		if err := chromedp.Evaluate(gridItemsJS, &items).Do(ctx); err != nil {
+			return err
+		}
+		if len(items) > 0 {
+			if *verboseFlag {
+				log.Printf("Most recently added item is: %v", items[0].Href)
+			}
+			return navigate(ctx, items[0].Href)
+		}
+	}
+	return errors.New("no items in the recently added view")
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// partnerDir is the directory, in dlDir, where -partner downloads the items
+// shared by the partner. It is the download and state directory of the
+// partner pass, so these items are never mixed with the user's own.
+const partnerDir = "partner"
+
+// partnerLinkJS returns the link, from the sharing page, to the library shared
+// by the partner, or the empty string if there is no partner sharing.
+const partnerLinkJS = `(function() {
+	for (const a of document.querySelectorAll('a[href]')) {
+		if (new URL(a.href).pathname.includes("/settings")) {
+			continue;
+		}
+		const text = (a.getAttribute("aria-label") || a.innerText || "").toLowerCase();
+		if (new URL(a.href).pathname.includes("/partner") || text.includes("partner")) {
+			return a.href;
+		}
+	}
+	return "";
+})()`
+
+// partnerDownload, with -partner, downloads the items of the library shared
+// by the partner, that are not in the partner ledger yet, into partnerDir. The
+// failed items are retried by the next partner pass.
+func (s *Session) partnerDownload(ctx context.Context) error {
+	var items []gridItem
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		if err := navigate(ctx, strings.TrimSuffix(s.photosURL, "/")+"/sharing"); err != nil {
+			return err
+		}
+		// the list of the shares fills itself asynchronously.
+		time.Sleep(2 * tick)
+		var link string
+		if err := chromedp.Evaluate(partnerLinkJS, &link).Do(ctx); err != nil {
+			return err
+		}
+		if link == "" {
+			return nil
+		}
+		if err := navigate(ctx, link); err != nil {
+			return err
+		}
+		var err error
+		items, err = scrollCollect(ctx, gridItemsJS)
+		return err
+	})); err != nil {
+		return err
+	}
+	if items == nil {
+		s.summary.warn("no library shared by a partner was found, for -partner")
+		return nil
+	}
+	log.Printf("Found %d items in the partner library", len(items))
+
+	dlDir, stateDir, downloaded := s.dlDir, s.stateDir, s.downloaded
+	defer func() {
+		s.dlDir, s.stateDir, s.downloaded = dlDir, stateDir, downloaded
+	}()
+	s.dlDir = filepath.Join(dlDir, partnerDir)
+	s.stateDir = filepath.Join(stateDir, partnerDir)
+	for _, dir := range []string{s.dlDir, s.stateDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	if err := s.loadDownloaded(); err != nil {
+		return err
+	}
+	if err := s.recoverInterrupted(); err != nil {
+		return err
+	}
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		return s.downloadItems(ctx, items)
+	})); err != nil {
+		return err
+	}
+	if s.summary.Partial {
+		return nil
+	}
+	return s.retryFailed(ctx)
+}
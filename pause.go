@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// pauseFile is the name of the file, in the state directory, whose existence pauses the
+// crawl.
+const pauseFile = ".pause"
+
+// paused is set to 1 by pauseSignal, and back to 0 by resumeSignal.
+var paused int32
+
+// watchPauseSignals makes pauseSignal and resumeSignal pause and resume the
+// crawl of all the sessions.
+func watchPauseSignals() {
+	if pauseSignal == nil {
+		return
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, pauseSignal, resumeSignal)
+	go func() {
+		for sig := range c {
+			if sig == pauseSignal {
+				log.Printf("Received %v, pausing after the current item", sig)
+				atomic.StoreInt32(&paused, 1)
+			} else {
+				log.Printf("Received %v, resuming", sig)
+				atomic.StoreInt32(&paused, 0)
+			}
+		}
+	}()
+}
+
+// isPaused reports whether the crawl of s should be paused, because of a
+// signal, or of the pause file.
+func (s *Session) isPaused() bool {
+	if atomic.LoadInt32(&paused) == 1 {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(s.stateDir, pauseFile))
+	return err == nil
+}
+
+// waitIfPaused returns once the crawl is not paused anymore. The browser, with
+// its session and the current item, is left untouched in the meantime.
+func (s *Session) waitIfPaused(ctx context.Context) error {
+	if !s.isPaused() {
+		return nil
+	}
+	if resumeSignal != nil {
+		log.Printf("Paused, remove %v or send %v to resume", filepath.Join(s.stateDir, pauseFile), resumeSignal)
+	} else {
+		log.Printf("Paused, remove %v to resume", filepath.Join(s.stateDir, pauseFile))
+	}
+	start := time.Now()
+	for s.isPaused() {
+		s.beat(s.heartbeat().Item, "paused")
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	log.Printf("Resuming after a pause of %v", time.Since(start).Round(time.Second))
+	return nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// peopleFile is the name of the file, in the download directory, that maps the
+// name of each person to the IDs of the items they appear in, with -metadata.
+const peopleFile = "people.json"
+
+// peopleJS returns the names of the people shown in the info panel of the
+// viewer. Each person is a link to their search page, labeled with their name.
+// Unnamed faces have no label, and are ignored.
+const peopleJS = `(function() {
+	const names = [];
+	for (const a of document.querySelectorAll('a[href*="/people/"], a[href*="/search/"]')) {
+		const r = a.getBoundingClientRect();
+		if (r.width === 0 || r.height === 0) {
+			continue;
+		}
+		const name = (a.getAttribute("aria-label") || a.innerText || "").trim();
+		if (name !== "" && !names.includes(name)) {
+			names.push(name);
+		}
+	}
+	return names;
+})()`
+
+// writePeopleIndex writes the people file in dlDir, from the metadata sidecar
+// files of all the items downloaded so far.
+func writePeopleIndex(dlDir string) error {
+	people := make(map[string][]string)
+	err := filepath.Walk(dlDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if path != dlDir && reservedDir(fi.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Name() != metadataFile {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var info itemInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			// not fatal, the sidecar is rewritten when the item is downloaded again.
+			return nil
+		}
+		for _, name := range info.People {
+			people[name] = append(people[name], info.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, ids := range people {
+		sort.Strings(ids)
+	}
+	data, err := json.MarshalIndent(people, "", "\t")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dlDir, peopleFile)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+)
+
+// errAbandoned is the error we quarantine an item with, when the run ended
+// before its pipelined download was complete.
+var errAbandoned = errors.New("download abandoned by the end of the run")
+
+// pendingItem is an item whose download has begun, with -pipeline, while we
+// have already moved on to the next items.
+type pendingItem struct {
+	location string
+	kind     string
+	info     *itemInfo
+	guid     string
+	// done receives the outcome of the download.
+	done chan pendingResult
+}
+
+type pendingResult struct {
+	dl  dlProgress
+	err error
+}
+
+// beginItem reads the metadata of the currently viewed item, at location,
+// with -metadata, applies the filters, and begins its download. It returns
+// as soon as the browser has begun the download, which waitPending then
+// waits for.
+func (s *Session) beginItem(ctx context.Context, location string) (*pendingItem, error) {
+	defer traceStep("item", location)()
+	if err := checkNonMedia(ctx, location); err != nil {
+		return nil, err
+	}
+	var info *itemInfo
+	if *metadataFlag {
+		var err error
+		info, err = readItemInfo(ctx, location)
+		if err != nil {
+			return nil, err
+		}
+	}
+	kind, err := s.checkFilters(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+	guid, err := s.beginDownload(ctx, location, startDownload)
+	if err != nil {
+		return nil, err
+	}
+	return &pendingItem{location: location, kind: kind, info: info, guid: guid, done: make(chan pendingResult, 1)}, nil
+}
+
+// waitPending adds p to the pending items, and waits in the background for
+// the completion of its download. ctx must outlive the item, as it is used
+// for the whole download.
+func (s *Session) waitPending(ctx context.Context, p *pendingItem) {
+	s.pending = append(s.pending, p)
+	go func() {
+		dl, err := s.waitDownload(ctx, p.location, p.guid)
+		p.done <- pendingResult{dl, err}
+	}()
+}
+
+// finishPending stores the pending items whose downloads are complete, in the
+// order they were begun, and waits for the oldest ones until at most keep are
+// still in flight. Failed downloads are not retried right away, as we are not
+// viewing their item anymore, but queued in the failed queue.
+func (s *Session) finishPending(ctx context.Context, keep int) error {
+	for len(s.pending) > 0 {
+		p := s.pending[0]
+		var r pendingResult
+		if len(s.pending) <= keep {
+			select {
+			case r = <-p.done:
+			default:
+				return nil
+			}
+		} else {
+			select {
+			case r = <-p.done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		s.pending = s.pending[1:]
+		err := r.err
+		if err == nil {
+			if err = checkDownload(r.dl, p.info); err != nil {
+				if rmErr := os.Remove(r.dl.path()); rmErr != nil {
+					return rmErr
+				}
+			}
+		}
+		var paths []string
+		if err == nil {
+			paths, err = s.storeItem(ctx, p.location, p.kind, p.info, []dlProgress{r.dl})
+		}
+		if err := s.itemDone(ctx, p.location, paths, p.info, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// abandonPending queues in the failed queue the items still pending when
+// navN returns early, as they were already marked as done.
+func (s *Session) abandonPending() {
+	for _, p := range s.pending {
+		if err := s.quarantine(p.location, errAbandoned); err != nil {
+			log.Printf("Error queuing %v: %v", p.location, err)
+		}
+	}
+	s.pending = nil
+}
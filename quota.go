@@ -0,0 +1,151 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// quotaPath is the path, relative to photosURL, of the storage management
+// page, which reports the storage used by the account, and its quota.
+const quotaPath = "quotamanagement"
+
+// quotaWarning is the fraction of the quota used from which we warn that
+// Google could soon reject new items.
+const quotaWarning = 0.95
+
+// storageUsage is the storage usage of the account, as recorded in the run
+// summary.
+type storageUsage struct {
+	// Used and Quota are the bytes used by the account, over all the Google
+	// services, and its quota.
+	Used  int64 `json:"used"`
+	Quota int64 `json:"quota,omitempty"`
+	// Photos is the part of Used taken by Google Photos, which is an estimate
+	// of the size of the library, if known.
+	Photos int64 `json:"photos,omitempty"`
+	// Local is the size of the items downloaded so far.
+	Local int64 `json:"local"`
+}
+
+var (
+	storageUsedRx   = regexp.MustCompile(`(?i)([\d.,]+\s?[KMGT]?B) (?:of|used of) ([\d.,]+\s?[KMGT]B)`)
+	photosStorageRx = regexp.MustCompile(`(?i)^(?:Google )?Photos\s*[:·]?\s*([\d.,]+\s?[KMGT]?B)$`)
+	sizeOnlyRx      = regexp.MustCompile(`(?i)^[\d.,]+\s?[KMGT]?B$`)
+)
+
+// parseStorage returns the storage usage found in the lines of the quota
+// page. ok is false if the used storage could not be found.
+func parseStorage(lines []string) (u storageUsage, ok bool) {
+	size := func(v string) int64 {
+		n, err := parseSize(strings.Replace(v, ",", "", -1))
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+	for i, l := range lines {
+		if m := storageUsedRx.FindStringSubmatch(l); m != nil && !ok {
+			u.Used, u.Quota, ok = size(m[1]), size(m[2]), true
+		}
+		if m := photosStorageRx.FindStringSubmatch(l); m != nil && u.Photos == 0 {
+			u.Photos = size(m[1])
+		} else if strings.EqualFold(l, "Google Photos") && i+1 < len(lines) && sizeOnlyRx.MatchString(lines[i+1]) && u.Photos == 0 {
+			u.Photos = size(lines[i+1])
+		}
+	}
+	return u, ok
+}
+
+// reportStorage logs the storage usage of the account, and records it in the
+// run summary, warning when the account is close to its quota. It then goes
+// back to the main page. Not finding the usage is not an error.
+func (s *Session) reportStorage(ctx context.Context) error {
+	quotaURL := strings.TrimSuffix(s.photosURL, "/") + "/" + quotaPath
+	var lines []string
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		if err := navigate(ctx, quotaURL); err != nil {
+			return err
+		}
+		// the page fills itself asynchronously.
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(tick) {
+			if err := chromedp.Evaluate(`document.body.innerText.split("\n").map(l => l.trim()).filter(l => l)`, &lines).Do(ctx); err != nil {
+				return err
+			}
+			if _, ok := parseStorage(lines); ok {
+				break
+			}
+		}
+		return navigate(ctx, s.photosURL)
+	})); err != nil {
+		return err
+	}
+	u, ok := parseStorage(lines)
+	if !ok {
+		log.Printf("Could not find the storage usage of the account in %v", quotaURL)
+		return nil
+	}
+	entries, err := loadLedger(s.stateDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		u.Local += e.Size
+	}
+	s.summary.Storage = &u
+
+	msg := fmt.Sprintf("Storage: %v used", formatSize(u.Used))
+	if u.Quota > 0 {
+		msg += fmt.Sprintf(" of %v", formatSize(u.Quota))
+	}
+	if u.Photos > 0 {
+		msg += fmt.Sprintf(", %v by Google Photos", formatSize(u.Photos))
+		if u.Photos > u.Local {
+			msg += fmt.Sprintf(", about %v left to download", formatSize(u.Photos-u.Local))
+		}
+	}
+	log.Print(msg)
+	if u.Quota > 0 && float64(u.Used) >= quotaWarning*float64(u.Quota) {
+		s.summary.warn(fmt.Sprintf("the account uses %.0f%% of its storage quota, Google could soon reject new items", 100*float64(u.Used)/float64(u.Quota)))
+	}
+	return nil
+}
+
+// formatSize returns n bytes in the largest unit it has at least one of.
+func formatSize(n int64) string {
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"TB", 1 << 40},
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+	} {
+		if n >= u.mult {
+			return fmt.Sprintf("%.1f %s", float64(n)/float64(u.mult), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestParseStorage(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		want   storageUsage
+		wantOK bool
+	}{
+		{
+			name:  "nothing",
+			lines: []string{"Storage", "Get more storage"},
+		},
+		{
+			name:   "used of quota",
+			lines:  []string{"Storage", "7.5 GB of 15 GB used"},
+			want:   storageUsage{Used: 15 << 29, Quota: 15 << 30},
+			wantOK: true,
+		},
+		{
+			name:   "used of quota, with the photos",
+			lines:  []string{"1,024 MB used of 2 TB", "Google Photos: 512 MB"},
+			want:   storageUsage{Used: 1 << 30, Quota: 2 << 40, Photos: 512 << 20},
+			wantOK: true,
+		},
+		{
+			name:   "photos on the next line",
+			lines:  []string{"1 GB of 100 GB", "Google Photos", "300 MB"},
+			want:   storageUsage{Used: 1 << 30, Quota: 100 << 30, Photos: 300 << 20},
+			wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		got, ok := parseStorage(tt.lines)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("%v: parseStorage = %+v, %v, want %+v, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
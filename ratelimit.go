@@ -0,0 +1,163 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// timeWindow is a daily time range, such as 08:00-22:00. It may span midnight,
+// e.g. 22:00-06:00.
+type timeWindow struct {
+	// start and end are offsets from midnight.
+	start, end time.Duration
+}
+
+// parseTimeOfDay parses a time of day such as 08:00 into an offset from midnight.
+// 24:00 is the end of the day.
+func parseTimeOfDay(v string) (time.Duration, error) {
+	parts := strings.Split(v, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time of day %q, want HH:MM", v)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid hour in time of day %q", v)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid minutes in time of day %q", v)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// parseTimeWindow parses a window of the form HH:MM-HH:MM.
+func parseTimeWindow(v string) (timeWindow, error) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) != 2 {
+		return timeWindow{}, fmt.Errorf("invalid time window %q, want HH:MM-HH:MM", v)
+	}
+	start, err := parseTimeOfDay(parts[0])
+	if err != nil {
+		return timeWindow{}, err
+	}
+	end, err := parseTimeOfDay(parts[1])
+	if err != nil {
+		return timeWindow{}, err
+	}
+	return timeWindow{start: start, end: end}, nil
+}
+
+// contains reports whether the time of day of t is within w.
+func (w timeWindow) contains(t time.Time) bool {
+	y, m, d := t.Date()
+	sinceMidnight := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if w.start <= w.end {
+		return sinceMidnight >= w.start && sinceMidnight < w.end
+	}
+	return sinceMidnight >= w.start || sinceMidnight < w.end
+}
+
+// nextStart returns the first time, from t on, within w.
+func (w timeWindow) nextStart(t time.Time) time.Time {
+	if w.contains(t) {
+		return t
+	}
+	y, m, d := t.Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Add(w.start)
+	if start.Before(t) {
+		start = start.AddDate(0, 0, 1)
+	}
+	return start
+}
+
+// rateWindow is a download rate limit that only applies during a daily window.
+type rateWindow struct {
+	window timeWindow
+	rate   int64 // in bytes per second
+}
+
+// parseRateSchedule parses a comma separated list of rate windows, such as
+// "08:00-22:00=2MBps,22:00-23:00=5MBps".
+func parseRateSchedule(v string) ([]rateWindow, error) {
+	var schedule []rateWindow
+	for _, entry := range strings.Split(v, ",") {
+		parts := strings.Split(entry, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid rate schedule entry %q, want HH:MM-HH:MM=rate", entry)
+		}
+		w, err := parseTimeWindow(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		rate, err := parseRate(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, rateWindow{window: w, rate: rate})
+	}
+	return schedule, nil
+}
+
+// rateLimitAt returns the download rate limit, in bytes per second, that
+// applies at t. It is the rate of the first window of the schedule that
+// contains t, or s.rateLimit otherwise. Zero means no limit.
+func (s *Session) rateLimitAt(t time.Time) int64 {
+	for _, rw := range s.rateSchedule {
+		if rw.window.contains(t) {
+			return rw.rate
+		}
+	}
+	return s.rateLimit
+}
+
+// applyRateLimit throttles the browser's network to the rate limit currently
+// in effect, if it changed since the last call.
+func (s *Session) applyRateLimit(ctx context.Context) error {
+	rate := s.rateLimitAt(time.Now())
+	if rate == s.appliedRate {
+		return nil
+	}
+	if err := throttle(ctx, rate); err != nil {
+		return err
+	}
+	if rate == 0 {
+		log.Printf("Network throttling disabled")
+	} else {
+		log.Printf("Network throttled to %d bytes/s", rate)
+	}
+	s.appliedRate = rate
+	return nil
+}
+
+// throttle throttles the network of the target of ctx to rate bytes per
+// second, or disables the throttling if rate is zero.
+func throttle(ctx context.Context, rate int64) error {
+	throughput := float64(rate)
+	if rate == 0 {
+		// disables throttling
+		throughput = -1
+	}
+	return network.EmulateNetworkConditions(false, 0, throughput, throughput).Do(ctx)
+}
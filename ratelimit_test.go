@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    timeWindow
+		wantErr bool
+	}{
+		{in: "01:00-07:00", want: timeWindow{start: time.Hour, end: 7 * time.Hour}},
+		{in: " 22:30-06:15 ", want: timeWindow{start: 22*time.Hour + 30*time.Minute, end: 6*time.Hour + 15*time.Minute}},
+		{in: "00:00-24:00", want: timeWindow{start: 0, end: 24 * time.Hour}},
+		{in: "01:00", wantErr: true},
+		{in: "1-7", wantErr: true},
+		{in: "25:00-07:00", wantErr: true},
+		{in: "01:60-07:00", wantErr: true},
+		{in: "22:00-24:30", wantErr: true},
+		{in: "01:00-07:00-08:00", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeWindow(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimeWindow(%q) error = %v, want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseTimeWindow(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRateSchedule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []rateWindow
+		wantErr bool
+	}{
+		{
+			in:   "08:00-18:00=1MB/s",
+			want: []rateWindow{{window: timeWindow{start: 8 * time.Hour, end: 18 * time.Hour}, rate: 1 << 20}},
+		},
+		{
+			in: "08:00-18:00=500KB/s,18:00-23:00=2MB/s",
+			want: []rateWindow{
+				{window: timeWindow{start: 8 * time.Hour, end: 18 * time.Hour}, rate: 500 << 10},
+				{window: timeWindow{start: 18 * time.Hour, end: 23 * time.Hour}, rate: 2 << 20},
+			},
+		},
+		{in: "08:00-18:00", wantErr: true},
+		{in: "08:00-18:00=", wantErr: true},
+		{in: "08:00-18:00=0KB/s", wantErr: true},
+		{in: "8-18=1MB/s", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseRateSchedule(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRateSchedule(%q) error = %v, want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRateSchedule(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"github.com/mailru/easyjson"
+)
+
+// recordFile is the name of the file, in the -record directory, where the
+// CDP events and our actions are recorded.
+const recordFile = "session.jsonl"
+
+// replayCommand is the subcommand that replays a recording made with -record.
+const replayCommand = "replay"
+
+// recordEntry is a line of the record file.
+type recordEntry struct {
+	Time time.Time `json:"time"`
+	// Event is the Go type of a CDP event, e.g. *page.EventDownloadProgress,
+	// and Action is one of our actions, e.g. "key", "nav", or "location".
+	Event  string          `json:"event,omitempty"`
+	Action string          `json:"action,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// recorder writes the record file.
+type recorder struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+	f  *os.File
+}
+
+// rec is the recorder of -record, if set.
+var rec *recorder
+
+// startRecording opens the record file in dir, for -record.
+func startRecording(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, recordFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	rec = &recorder{f: f, w: bufio.NewWriter(f)}
+	return nil
+}
+
+// stopRecording flushes and closes the record file.
+func stopRecording() {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if err := rec.w.Flush(); err != nil {
+		log.Printf("Error writing recording: %v", err)
+	}
+	rec.f.Close()
+}
+
+func (r *recorder) write(e recordEntry) {
+	if r == nil {
+		return
+	}
+	e.Time = time.Now()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w.Write(append(data, '\n'))
+}
+
+// action records one of our actions, with its detail.
+func (r *recorder) action(name, detail string) {
+	if r == nil {
+		return
+	}
+	data, _ := json.Marshal(detail)
+	r.write(recordEntry{Action: name, Data: data})
+	// so the trail of our actions survives a crash.
+	r.mu.Lock()
+	r.w.Flush()
+	r.mu.Unlock()
+}
+
+// listen records the CDP events of the target of ctx that replay uses.
+// The others, e.g. the extra info of the network requests, carry the cookies
+// of the Google session, which must not end up in a recording meant to be
+// shared.
+func (r *recorder) listen(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		name := fmt.Sprintf("%T", ev)
+		if _, ok := replayedEvents[name]; !ok {
+			return
+		}
+		if resp, ok := ev.(*network.EventResponseReceived); ok && resp.Response != nil {
+			// the Cookie and Set-Cookie headers.
+			stripped, response := *resp, *resp.Response
+			response.Headers, response.HeadersText = nil, ""
+			response.RequestHeaders, response.RequestHeadersText = nil, ""
+			stripped.Response = &response
+			ev = &stripped
+		}
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		r.write(recordEntry{Event: name, Data: data})
+	})
+}
+
+// replayedEvents are the events that replay feeds back to our event
+// handlers, by Go type.
+var replayedEvents = map[string]cdproto.MethodType{
+	"*page.EventDownloadWillBegin":       cdproto.EventPageDownloadWillBegin,
+	"*page.EventDownloadProgress":        cdproto.EventPageDownloadProgress,
+	"*page.EventNavigatedWithinDocument": cdproto.EventPageNavigatedWithinDocument,
+	"*network.EventResponseReceived":     cdproto.EventNetworkResponseReceived,
+}
+
+// replay reads the recording in dir, and feeds its download events to the
+// download event handler, printing the timeline of the session, and the
+// anomalies found: navigations that would have stalled, downloads that never
+// completed, and rate limiting. It only reports the anomalies: the crawl
+// itself (firstNav, navN, and their skip and stall recoveries) is not run
+// again. It returns the process exit code, 1 if there are anomalies.
+func replay(dir string) int {
+	f, err := os.Open(filepath.Join(dir, recordFile))
+	if err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+	defer f.Close()
+
+	s := &Session{
+		downloads: make(map[string]*dlProgress),
+		dlBegun:   make(chan string, 1),
+	}
+	anomalies := 0
+	anomaly := func(t time.Time, format string, args ...interface{}) {
+		anomalies++
+		fmt.Printf("%s ANOMALY: %s\n", t.Format("15:04:05.000"), fmt.Sprintf(format, args...))
+	}
+	// the pending navigation, if any, and when it was requested.
+	var navPending string
+	var navSince time.Time
+	var last time.Time
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 16<<20)
+	for sc.Scan() {
+		var e recordEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			log.Printf("Invalid record: %v", err)
+			return exitFailure
+		}
+		last = e.Time
+		if navPending != "" && e.Time.Sub(navSince) > time.Minute {
+			anomaly(e.Time, "no %v navigation within a minute: navigation stall", navPending)
+			navPending = ""
+		}
+		if e.Action != "" {
+			var detail string
+			json.Unmarshal(e.Data, &detail)
+			fmt.Printf("%s %s %s\n", e.Time.Format("15:04:05.000"), e.Action, detail)
+			if e.Action == "nav" {
+				navPending, navSince = detail, e.Time
+			}
+			continue
+		}
+		method, ok := replayedEvents[e.Event]
+		if !ok {
+			continue
+		}
+		ev, err := cdproto.UnmarshalMessage(&cdproto.Message{Method: method, Params: easyjson.RawMessage(e.Data)})
+		if err != nil {
+			log.Printf("Invalid %v event: %v", e.Event, err)
+			continue
+		}
+		switch ev := ev.(type) {
+		case *page.EventNavigatedWithinDocument:
+			fmt.Printf("%s navigated to %s\n", e.Time.Format("15:04:05.000"), ev.URL)
+			navPending = ""
+		case *network.EventResponseReceived:
+			if ev.Response.Status == 429 {
+				anomaly(e.Time, "rate limited at %v", ev.Response.URL)
+			}
+		default:
+			s.onDownloadEvent(ev)
+			// the crawl drains the notifications, while it waits for them.
+			select {
+			case guid := <-s.dlBegun:
+				fmt.Printf("%s download %v began\n", e.Time.Format("15:04:05.000"), guid)
+			default:
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+	if navPending != "" {
+		anomaly(last, "no %v navigation before the end of the recording", navPending)
+	}
+	for guid, dl := range s.downloads {
+		switch dl.state {
+		case page.DownloadProgressStateCompleted:
+			fmt.Printf("download %v (%v): %d bytes\n", guid, dl.filename, dl.received)
+		default:
+			anomaly(last, "download %v (%v) ended %v, after %d of %d bytes", guid, dl.filename, dl.state, dl.received, dl.total)
+		}
+	}
+	if anomalies > 0 {
+		return exitFailure
+	}
+	return 0
+}
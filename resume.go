@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// maxResumeAttempts is how many items of the ledger, recorded before the last
+// done item, are tried when the last done item does not exist anymore.
+const maxResumeAttempts = 20
+
+// resumeCandidates returns the URLs of the timeline items of entries recorded
+// before the item with ID lastID, most recent first. If lastID is not in
+// entries, they are all candidates.
+func (s *Session) resumeCandidates(entries []ledgerEntry, lastID string) []string {
+	end := len(entries)
+	for i, e := range entries {
+		if e.ID == lastID {
+			end = i
+			break
+		}
+	}
+	var urls []string
+	for i := end - 1; i >= 0 && len(urls) < maxResumeAttempts; i-- {
+		// items from shared albums, or other places out of the timeline,
+		// are no point to resume from.
+		if !strings.HasPrefix(entries[i].URL, strings.TrimSuffix(s.photosURL, "/")+"/photo/") {
+			continue
+		}
+		urls = append(urls, entries[i].URL)
+	}
+	return urls
+}
+
+// navToResume is called when the last done item does not exist anymore. It
+// walks backwards through the ledger, to the most recent item that still
+// exists, navigates to it, and makes it the last done item. It reports
+// whether it found one.
+func (s *Session) navToResume(ctx context.Context) (bool, error) {
+	entries, err := loadLedger(s.stateDir)
+	if err != nil {
+		return false, err
+	}
+	lastID, _ := itemID(s.lastDone)
+	for _, location := range s.resumeCandidates(entries, lastID) {
+		resp, err := chromedp.RunResponse(ctx, chromedp.Navigate(location))
+		if err != nil {
+			return false, err
+		}
+		if resp.Status != http.StatusOK {
+			if *verboseFlag {
+				log.Printf("%s does not seem to exist anymore either", location)
+			}
+			continue
+		}
+		log.Printf("Resuming from %s, the most recent downloaded item that still exists", location)
+		s.lastDone = location
+		if err := markDone(s.stateDir, location); err != nil {
+			return false, err
+		}
+		chromedp.WaitReady("body", chromedp.ByQuery).Do(ctx)
+		return true, dismissDialogs(ctx)
+	}
+	return false, nil
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// runData is what the arguments of the -run and -run-onfail commands are
+// executed against, as templates.
+type runData struct {
+	// Path is the path of the downloaded file.
+	Path string
+	// ID and URL are those of the item the file belongs to.
+	ID  string
+	URL string
+	// CaptureDate (as YYYY-MM-DD) and Taken are when the item was taken, if
+	// known, which requires -metadata.
+	CaptureDate string
+	Taken       time.Time
+	// Error is why the -run command failed, for -run-onfail.
+	Error string
+}
+
+// splitCommand splits cmd in arguments, on white space, except within
+// template actions. There is no quoting: use a script for anything fancier.
+func splitCommand(cmd string) []string {
+	var args []string
+	var arg strings.Builder
+	depth := 0
+	for i := 0; i < len(cmd); i++ {
+		switch {
+		case strings.HasPrefix(cmd[i:], "{{"):
+			depth++
+			arg.WriteString("{{")
+			i++
+			continue
+		case strings.HasPrefix(cmd[i:], "}}") && depth > 0:
+			depth--
+			arg.WriteString("}}")
+			i++
+			continue
+		case depth == 0 && (cmd[i] == ' ' || cmd[i] == '\t'):
+			if arg.Len() > 0 {
+				args = append(args, arg.String())
+				arg.Reset()
+			}
+			continue
+		}
+		arg.WriteByte(cmd[i])
+	}
+	if arg.Len() > 0 {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+// parseCommand parses cmd as a command line whose arguments are templates.
+// For compatibility, a cmd without any template action is the program to run,
+// with defaultArg (e.g. "{{.Path}}") as its only argument.
+func parseCommand(cmd, defaultArg string) ([]*template.Template, error) {
+	if !strings.Contains(cmd, "{{") {
+		return []*template.Template{
+			template.Must(template.New("").Parse(cmd)),
+			template.Must(template.New("").Parse(defaultArg)),
+		}, nil
+	}
+	var argv []*template.Template
+	for _, arg := range splitCommand(cmd) {
+		t, err := template.New("").Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command %q: %v", cmd, err)
+		}
+		argv = append(argv, t)
+	}
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("empty command %q", cmd)
+	}
+	return argv, nil
+}
+
+// runner runs the -run command on the downloaded files, on a pool of
+// -run-concurrency workers.
+type runner struct {
+	argv   []*template.Template
+	onFail []*template.Template // nil if no -run-onfail
+
+	jobs chan runData
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	err  error // first failure, when there is no onFail
+}
+
+// newRunner returns a runner for the -run and -run-onfail flags, or nil if
+// -run is not set.
+func newRunner() (*runner, error) {
+	if *runFlag == "" {
+		return nil, nil
+	}
+	argv, err := parseCommand(*runFlag, "{{.Path}}")
+	if err != nil {
+		return nil, err
+	}
+	r := &runner{argv: argv}
+	if *runOnFailFlag != "" {
+		if r.onFail, err = parseCommand(*runOnFailFlag, "{{.Path}}"); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// start starts the workers. It must be followed by a call to wait.
+func (r *runner) start() {
+	if r == nil {
+		return
+	}
+	r.err = nil
+	r.jobs = make(chan runData)
+	n := *runConcurrencyFlag
+	if n < 1 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			for data := range r.jobs {
+				r.do(data)
+			}
+		}()
+	}
+}
+
+// wait waits for all the submitted commands to finish, and stops the
+// workers. It returns the first failure of the -run command, unless
+// -run-onfail handled it.
+func (r *runner) wait() error {
+	if r == nil || r.jobs == nil {
+		return nil
+	}
+	close(r.jobs)
+	r.wg.Wait()
+	r.jobs = nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// submit queues the -run command for data, waiting for a worker to be
+// available. It returns an error if a previous command failed, and there is
+// no -run-onfail to handle it.
+func (r *runner) submit(data runData) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	err := r.err
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	r.jobs <- data
+	return nil
+}
+
+// do runs the -run command for data, and -run-onfail if it fails.
+func (r *runner) do(data runData) {
+	err := runCommand(r.argv, data)
+	if err == nil {
+		return
+	}
+	err = fmt.Errorf("command %q on %v: %w", *runFlag, data.Path, err)
+	if r.onFail == nil {
+		r.mu.Lock()
+		if r.err == nil {
+			r.err = err
+		}
+		r.mu.Unlock()
+		return
+	}
+	log.Print(err)
+	data.Error = err.Error()
+	if err := runCommand(r.onFail, data); err != nil {
+		log.Printf("Command %q on %v failed: %v", *runOnFailFlag, data.Path, err)
+	}
+}
+
+// runCommand runs the command line argv, executed against data, killing it
+// after -run-timeout.
+func runCommand(argv []*template.Template, data interface{}) error {
+	var args []string
+	for _, t := range argv {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return err
+		}
+		args = append(args, buf.String())
+	}
+	ctx := context.Background()
+	if *runTimeoutFlag > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *runTimeoutFlag)
+		defer cancel()
+	}
+	if *verboseFlag {
+		log.Printf("Running %q", args)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	// not in the way of the -print records.
+	cmd.Stdout = userOutput()
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("killed after %v", *runTimeoutFlag)
+	}
+	return err
+}
+
+// postProcess submits the -run command for the files at paths, of the item at
+// location, described by info if not nil, adds them to the -batchrun batch,
+// and queues them for -upload.
+func (s *Session) postProcess(paths []string, location string, info *itemInfo) error {
+	id, _ := itemID(location)
+	data := runData{ID: id, URL: location}
+	if info != nil && !info.Taken.IsZero() {
+		data.Taken = info.Taken
+		data.CaptureDate = info.Taken.Format("2006-01-02")
+	}
+	for _, path := range paths {
+		data.Path = path
+		if err := s.runner.submit(data); err != nil {
+			return err
+		}
+	}
+	if err := s.addToBatch(paths); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := s.uploads.add(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
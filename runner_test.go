@@ -0,0 +1,43 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{in: "", want: nil},
+		{in: "pk-put file", want: []string{"pk-put", "file"}},
+		{in: "  pk-put \t file  ", want: []string{"pk-put", "file"}},
+		{in: "cp {{.Path}} /backup", want: []string{"cp", "{{.Path}}", "/backup"}},
+		{in: `tag {{printf "%s %s" .ID .Path}} -v`, want: []string{"tag", `{{printf "%s %s" .ID .Path}}`, "-v"}},
+		{in: "echo {{if .Video}}{{.Path}}{{end}}", want: []string{"echo", "{{if .Video}}{{.Path}}{{end}}"}},
+		{in: "echo a}}b", want: []string{"echo", "a}}b"}},
+	}
+	for _, tt := range tests {
+		got := splitCommand(tt.in)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
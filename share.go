@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// shareDownload downloads, with -share-url, all the items of the shared
+// album, which needs no login, that are not in the ledger yet.
+func (s *Session) shareDownload(ctx context.Context) error {
+	if err := s.prepareBrowser().Do(ctx); err != nil {
+		return err
+	}
+	if err := navigate(ctx, *shareURLFlag); err != nil {
+		return err
+	}
+	items, err := scrollCollect(ctx, gridItemsJS)
+	if err != nil {
+		return err
+	}
+	log.Printf("Found %d items in the shared album", len(items))
+	return s.downloadItems(ctx, items)
+}
+
+// downloadItems downloads, one after the other, the items collected from a
+// grid that are not in the ledger yet, up to -n of them. Each item is
+// navigated to directly.
+func (s *Session) downloadItems(ctx context.Context, items []gridItem) error {
+	n := 0
+	for _, it := range items {
+		if *nItemsFlag > 0 && n >= *nItemsFlag {
+			break
+		}
+		if s.timeUp() {
+			break
+		}
+		location := it.Href
+		counters.Add("items", 1)
+		s.beat(location, "running")
+		if err := s.waitIfPaused(ctx); err != nil {
+			return err
+		}
+		if err := s.checkFreeSpace(0); err != nil {
+			return err
+		}
+		switch {
+		case s.skipped(location):
+			log.Printf("Skipping %v, as it is in the skip list", location)
+			s.summary.addSkip(location)
+			s.printItem(location, actionSkipped, nil, 0, nil)
+			continue
+		case s.inLedger(location):
+			if *verboseFlag {
+				log.Printf("Skipping %v, as it was already downloaded", location)
+			}
+			s.printItem(location, actionKnown, nil, 0, nil)
+			continue
+		case *dryRunFlag:
+			id, err := itemID(location)
+			if err != nil {
+				return err
+			}
+			log.Printf("Would download %v into %v", location, filepath.Join(s.dlDir, id))
+			s.printItem(location, actionDryRun, nil, 0, nil)
+			n++
+			continue
+		}
+		if err := s.applyRateLimit(ctx); err != nil {
+			return err
+		}
+		if err := s.coolDownIfThrottled(ctx); err != nil {
+			return err
+		}
+		s.startItem(location)
+		if err := navigate(ctx, location); err != nil {
+			return err
+		}
+		// let the viewer settle, so it gets our key events.
+		time.Sleep(settle())
+		var filePaths []string
+		var info *itemInfo
+		err := s.withWatchdog(ctx, func(ctx context.Context) error {
+			var err error
+			filePaths, info, err = s.dlAndMove(ctx, location)
+			return err
+		})
+		if err := s.itemDone(ctx, location, filePaths, info, err); err != nil {
+			return err
+		}
+		n++
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadSkipList reads the item IDs listed in the -skip file. Each line is an
+// item ID or URL. Empty lines, and lines starting with '#', are ignored.
+func loadSkipList(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	skip := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		item := strings.TrimSpace(sc.Text())
+		if item == "" || strings.HasPrefix(item, "#") {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			id, err := itemID(strings.TrimRight(item, "/"))
+			if err != nil {
+				return nil, fmt.Errorf("%v:%d: %v", path, line, err)
+			}
+			item = id
+		}
+		skip[item] = true
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return skip, nil
+}
+
+// skipped reports whether the item at location is in the -skip list.
+func (s *Session) skipped(location string) bool {
+	if len(s.skip) == 0 {
+		return false
+	}
+	id, err := itemID(location)
+	if err != nil {
+		return false
+	}
+	return s.skip[id]
+}
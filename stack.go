@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// stackMembersJS returns the links to the other members of the stack (e.g. a
+// burst) of the currently viewed item, from the strip of thumbnails the viewer
+// shows for stacks, or an empty list if the item is not stacked.
+const stackMembersJS = `(function() {
+	const members = [];
+	for (const strip of document.querySelectorAll('[aria-label*="stack" i], [aria-label*="burst" i]')) {
+		for (const a of strip.querySelectorAll('a[href*="/photo/"]')) {
+			const r = a.getBoundingClientRect();
+			if (r.width === 0 || r.height === 0 || location.href.startsWith(a.href) || members.includes(a.href)) {
+				continue;
+			}
+			members.push(a.href);
+		}
+	}
+	return members;
+})()`
+
+// downloadStack, with -stacks, downloads the other members of the stack of the
+// item at location, which the timeline hides, and returns their downloads,
+// named with an index suffix, and their IDs. It then goes back to the item at
+// location, for the navigation to go on from there.
+func (s *Session) downloadStack(ctx context.Context, location string) ([]dlProgress, []string, error) {
+	if !*stacksFlag {
+		return nil, nil, nil
+	}
+	var members []string
+	if err := chromedp.Evaluate(stackMembersJS, &members).Do(ctx); err != nil {
+		return nil, nil, err
+	}
+	if len(members) == 0 {
+		return nil, nil, nil
+	}
+	if *verboseFlag {
+		log.Printf("%v is stacked with %d other items", location, len(members))
+	}
+	var dls []dlProgress
+	var ids []string
+	for i, member := range members {
+		if err := navigate(ctx, member); err != nil {
+			return nil, nil, err
+		}
+		// let the viewer settle, so it gets our key events.
+		time.Sleep(2 * tick)
+		dl, err := s.downloadChecked(ctx, member, startDownload, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error downloading %v, of the stack of %v: %w", member, location, err)
+		}
+		dl.filename = withSuffix(dl.filename, fmt.Sprintf("-%d", i+1))
+		dls = append(dls, dl)
+		id, err := itemID(member)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := navigate(ctx, location); err != nil {
+		return nil, nil, err
+	}
+	time.Sleep(2 * tick)
+	return dls, ids, nil
+}
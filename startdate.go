@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+)
+
+// startDateLayout is the layout of -start, when it is a date rather than an
+// item URL.
+const startDateLayout = "2006-01-02"
+
+// parseStartDate returns the date in -start, if it is one.
+func parseStartDate() (time.Time, bool) {
+	date, err := time.Parse(startDateLayout, *startFlag)
+	return date, err == nil
+}
+
+// gridItem is an item of the timeline grid, as returned by gridItemsJS.
+type gridItem struct {
+	Href  string `json:"href"`
+	Label string `json:"label"`
+}
+
+// gridItemsJS returns the links to the items currently in the timeline grid,
+// with their labels, which include the date the item was taken.
+const gridItemsJS = `(function() {
+	const items = [];
+	for (const a of document.querySelectorAll('a[href*="/photo/"][aria-label]')) {
+		items.push({href: a.href, label: a.getAttribute("aria-label")});
+	}
+	return items;
+})()`
+
+var gridDateRx = regexp.MustCompile(`\b[A-Z][a-z]{2} \d{1,2}, \d{4}\b`)
+
+// navToDate scrolls down the timeline until it shows items taken before date,
+// and navigates to the oldest item taken on or after date, so the crawl starts
+// there. If the whole library is more recent than date, it navigates to the
+// oldest item, as without -start.
+func (s *Session) navToDate(ctx context.Context, date time.Time) error {
+	log.Printf("Looking for the first item taken on or after %v", date.Format(startDateLayout))
+	var previousScr, scr []byte
+	for {
+		var items []gridItem
+		if err := chromedp.Evaluate(gridItemsJS, &items).Do(ctx); err != nil {
+			return err
+		}
+		var start string
+		var startDate time.Time
+		older := false
+		for _, it := range items {
+			m := gridDateRx.FindString(it.Label)
+			if m == "" {
+				continue
+			}
+			taken, err := time.Parse("Jan 2, 2006", m)
+			if err != nil {
+				continue
+			}
+			if taken.Before(date) {
+				older = true
+				continue
+			}
+			// items are in reverse chronological order, so the last
+			// matching one is the oldest.
+			start, startDate = it.Href, taken
+		}
+		if older {
+			if start == "" {
+				log.Printf("No item taken on or after %v, starting with the most recent one", date.Format(startDateLayout))
+				start = strings.TrimSuffix(s.photosURL, "/") + "/photo/" + s.firstItem
+			} else {
+				log.Printf("Starting with %v, taken on %v", start, startDate.Format(startDateLayout))
+			}
+			if err := chromedp.Navigate(start).Do(ctx); err != nil {
+				return err
+			}
+			if err := chromedp.WaitReady("body", chromedp.ByQuery).Do(ctx); err != nil {
+				return err
+			}
+			return dismissDialogs(ctx)
+		}
+
+		keyEvent(ctx, kb.PageDown, focusKept)
+		time.Sleep(tick)
+		if err := chromedp.CaptureScreenshot(&scr).Do(ctx); err != nil {
+			return err
+		}
+		if previousScr != nil && bytes.Equal(previousScr, scr) {
+			// reached the end of the timeline.
+			break
+		}
+		previousScr = scr
+	}
+	log.Printf("The whole library is more recent than %v", date.Format(startDateLayout))
+	return s.navToLast(ctx)
+}
@@ -0,0 +1,363 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// stateCommand is the subcommand that exports, imports, merges, or rebuilds
+// the state of the download directory, without a browser.
+const stateCommand = "state"
+
+// stateFiles are the files of the state directory that are exported and
+// imported. The others (.lock, .heartbeat, .downloads, ...) only make sense
+// on the machine, and during the run, that wrote them.
+var stateFiles = []string{".lastdone", ledgerFile, failedFile, freedFile, summaryFile, hashesFile}
+
+const stateUsage = `usage:
+	%[1]v state export [flags] file.tar.gz
+		archive the state files (.lastdone, ledger, failed queue, ...)
+	%[1]v state import [flags] file.tar.gz
+		restore the state files of an export, merging its ledger with the current one
+	%[1]v state merge [flags] statedir...
+		merge the ledgers and failed queues of other state directories, e.g. of sharded runs
+	%[1]v state rebuild [flags]
+		add to the ledger the items found in the download directory
+The flags are the usual ones, of which -dldir and -state say which state to work on.`
+
+// stateMain runs the state subcommand action, with args, the arguments left
+// after the flags, and returns the exit code.
+func stateMain(action string, args []string) int {
+	usage := func() int {
+		fmt.Fprintf(os.Stderr, stateUsage+"\n", os.Args[0])
+		return exitFailure
+	}
+	var want int
+	switch action {
+	case "export", "import":
+		want = 1
+	case "rebuild":
+		want = 0
+	case "merge":
+		want = len(args)
+		if want == 0 {
+			return usage()
+		}
+	default:
+		return usage()
+	}
+	if len(args) != want {
+		return usage()
+	}
+	dlDir, stateDir, err := sessionDirs("")
+	if err != nil {
+		log.Print(err)
+		return exitDisk
+	}
+	// so the state does not change under a run, nor the other way around.
+	s := &Session{dlDir: dlDir, stateDir: stateDir}
+	if err := s.lockDlDir(); err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+	defer s.unlockDlDir()
+	switch action {
+	case "export":
+		err = exportState(stateDir, args[0])
+	case "import":
+		err = importState(stateDir, args[0])
+	case "merge":
+		err = mergeState(stateDir, args)
+	case "rebuild":
+		err = rebuildLedger(dlDir, stateDir)
+	}
+	if err != nil {
+		log.Print(err)
+		return exitCode(err)
+	}
+	return 0
+}
+
+// exportState writes the state files of stateDir, as a gzipped tar archive, to
+// path.
+func exportState(stateDir, path string) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	for _, name := range stateFiles {
+		data, err := ioutil.ReadFile(filepath.Join(stateDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data))}); err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+		log.Printf("Exported %v", name)
+	}
+	if err := tw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// importState restores in stateDir the state files of the archive at path,
+// made by exportState. The ledger and the failed queue are merged with the
+// current ones, and the other files replace theirs.
+func importState(stateDir, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	tmpDir, err := ioutil.TempDir(stateDir, ".import")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	tr := tar.NewReader(zr)
+	var imported []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+		if !isStateFile(hdr.Name) {
+			return fmt.Errorf("%v: unexpected file %q", path, hdr.Name)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, hdr.Name), data, 0600); err != nil {
+			return err
+		}
+		imported = append(imported, hdr.Name)
+	}
+	for _, name := range imported {
+		switch name {
+		case ledgerFile, failedFile:
+			// merged below, from tmpDir.
+		default:
+			if err := os.Rename(filepath.Join(tmpDir, name), filepath.Join(stateDir, name)); err != nil {
+				return err
+			}
+		}
+		log.Printf("Imported %v", name)
+	}
+	return mergeState(stateDir, []string{tmpDir})
+}
+
+func isStateFile(name string) bool {
+	for _, v := range stateFiles {
+		if name == v {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeState merges into stateDir the ledgers and failed queues of the state
+// directories others. An item in several ledgers keeps its most recent entry,
+// and the items of the merged ledger are dropped from the failed queue.
+func mergeState(stateDir string, others []string) error {
+	entries, err := loadLedger(stateDir)
+	if err != nil {
+		return err
+	}
+	failed, err := loadFailed(stateDir)
+	if err != nil {
+		return err
+	}
+	index := make(map[string]int)
+	for i, e := range entries {
+		index[e.ID] = i
+	}
+	failedIndex := make(map[string]bool)
+	for _, it := range failed {
+		failedIndex[it.Location] = true
+	}
+	added, updated := 0, 0
+	for _, dir := range others {
+		more, err := loadLedger(dir)
+		if err != nil {
+			return fmt.Errorf("%v: %v", dir, err)
+		}
+		for _, e := range more {
+			i, ok := index[e.ID]
+			switch {
+			case !ok:
+				index[e.ID] = len(entries)
+				entries = append(entries, e)
+				added++
+			case e.Time.After(entries[i].Time):
+				entries[i] = e
+				updated++
+			}
+		}
+		moreFailed, err := loadFailed(dir)
+		if err != nil {
+			return fmt.Errorf("%v: %v", dir, err)
+		}
+		for _, it := range moreFailed {
+			if !failedIndex[it.Location] {
+				failedIndex[it.Location] = true
+				failed = append(failed, it)
+			}
+		}
+	}
+	var remaining []failedItem
+	for _, it := range failed {
+		if id, err := itemID(it.Location); err == nil {
+			if _, ok := index[id]; ok {
+				continue
+			}
+		}
+		remaining = append(remaining, it)
+	}
+	if err := saveLedger(stateDir, entries); err != nil {
+		return err
+	}
+	if err := saveFailed(stateDir, remaining); err != nil {
+		return err
+	}
+	log.Printf("Ledger merged: %d items added, %d updated, %d in total; %d items in the failed queue", added, updated, len(entries), len(remaining))
+	return nil
+}
+
+// rebuildLedger adds to the ledger in stateDir an entry for each item
+// directory of dlDir that is not in it yet, e.g. for a download directory of
+// an older version, or whose ledger was lost. The URL of the item is the one
+// in its sidecar file, or the one of the main library otherwise.
+func rebuildLedger(dlDir, stateDir string) error {
+	entries, err := loadLedger(stateDir)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, e := range entries {
+		known[e.ID] = true
+	}
+	onDisk, err := localItems(dlDir)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for id := range onDisk {
+		if !known[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	added := 0
+	for _, id := range ids {
+		e, err := scanItemDir(dlDir, id)
+		if err != nil {
+			return err
+		}
+		if len(e.Files) == 0 {
+			log.Printf("Skipping %v, which has no files", id)
+			continue
+		}
+		entries = append(entries, e)
+		added++
+	}
+	if err := saveLedger(stateDir, entries); err != nil {
+		return err
+	}
+	log.Printf("Ledger rebuilt: %d items added, %d in total", added, len(entries))
+	return nil
+}
+
+// scanItemDir returns the ledger entry of the item id, from the files of its
+// directory in dlDir.
+func scanItemDir(dlDir, id string) (ledgerEntry, error) {
+	dir := filepath.Join(dlDir, id)
+	e := ledgerEntry{ID: id, URL: photosHome + "photo/" + id}
+	data, err := ioutil.ReadFile(filepath.Join(dir, metadataFile))
+	if err != nil && !os.IsNotExist(err) {
+		return e, err
+	}
+	if err == nil {
+		var info itemInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			return e, fmt.Errorf("%v: %v", filepath.Join(dir, metadataFile), err)
+		}
+		if info.URL != "" {
+			e.URL = info.URL
+		}
+		e.Original, e.Kind = info.Filename, info.Kind
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return e, err
+	}
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || name == metadataFile || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		e.Files = append(e.Files, id+"/"+name)
+		e.Size += fi.Size()
+		if fi.ModTime().After(e.Time) {
+			e.Time = fi.ModTime()
+		}
+	}
+	if e.Kind == "" && len(e.Files) > 0 {
+		e.Kind = fileKind(e.Files[0])
+	}
+	return e, nil
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const summaryFile = "run-summary.json"
+
+// runSummary is the machine-readable report of a run, written to
+// run-summary.json, in the state directory, when the run ends, successfully or not.
+type runSummary struct {
+	// mu protects the fields updated by the methods, which the other
+	// goroutines read, e.g. for the heartbeat.
+	mu sync.Mutex
+	runStats
+}
+
+// runStats are the fields of runSummary.
+type runStats struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration string    `json:"duration"`
+	// Partial is whether the run was stopped by -maxduration or -window, before getting
+	// through all the items.
+	Partial bool `json:"partial,omitempty"`
+	// DryRun is whether the run was a -dryrun, which downloaded nothing.
+	DryRun     bool  `json:"dryRun,omitempty"`
+	Downloaded int   `json:"downloaded"`
+	Skipped    int   `json:"skipped"`
+	Failed     int   `json:"failed"`
+	Bytes      int64 `json:"bytes"`
+	// NavSkipped is the number of items the viewer jumped over, which were
+	// then recovered, as detected against the list of -enumerate.
+	NavSkipped int `json:"navSkipped,omitempty"`
+	// Deduped is the number of files found to be duplicates, with -dedupe.
+	Deduped int `json:"deduped,omitempty"`
+	// FreedUp is the number of items moved to the trash with -free-up.
+	FreedUp int `json:"freedUp,omitempty"`
+	// CoolDowns is the number of times we paused because of rate limiting.
+	CoolDowns int `json:"coolDowns,omitempty"`
+	// Quality counts the downloaded items per storage quality, with -metadata.
+	Quality map[string]int `json:"quality,omitempty"`
+	// FirstItem and LastItem are the IDs of the first and last items
+	// downloaded during the run.
+	FirstItem string        `json:"firstItem,omitempty"`
+	LastItem  string        `json:"lastItem,omitempty"`
+	Failures  []itemFailure `json:"failures,omitempty"`
+	// SkippedItems are the items that were not downloaded because they are
+	// in the -skip list, or not selected by -only, -minsize, or -maxsize.
+	SkippedItems []string `json:"skippedItems,omitempty"`
+	// NonMedia are the pages met among the items that are not photos or
+	// videos, e.g. print orders, with why.
+	NonMedia []itemFailure `json:"nonMedia,omitempty"`
+	// Storage is the storage usage of the account, when the run started.
+	Storage *storageUsage `json:"storage,omitempty"`
+	// LockedFolder is the state of the Locked Folder: none, empty, items, or
+	// locked.
+	LockedFolder string `json:"lockedFolder,omitempty"`
+	// Warnings are the problems, short of failures, the user should know
+	// about.
+	Warnings []string `json:"warnings,omitempty"`
+	// Error is the error that ended the run, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// itemFailure records why an item could not be downloaded.
+type itemFailure struct {
+	Item  string `json:"item"`
+	Error string `json:"error"`
+}
+
+// reset starts the summary of a new run, begun at start, which is a -dryrun
+// if dryRun is set.
+func (rs *runSummary) reset(start time.Time, dryRun bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.runStats = runStats{Start: start, DryRun: dryRun}
+}
+
+// addDownload records in the summary that the item with the given ID was
+// downloaded, and its size.
+func (rs *runSummary) addDownload(id string, size int64) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.FirstItem == "" {
+		rs.FirstItem = id
+	}
+	rs.LastItem = id
+	rs.Downloaded++
+	rs.Bytes += size
+	counters.Add("downloads", 1)
+	counters.Add("bytes", size)
+}
+
+// downloaded returns the number of items downloaded so far.
+func (rs *runSummary) downloaded() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.Downloaded
+}
+
+// addDeduped records in the summary that a file was found to be a duplicate.
+func (rs *runSummary) addDeduped() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.Deduped++
+}
+
+// addQuality records in the summary the storage quality of a downloaded
+// item. An empty quality is counted as "unknown".
+func (rs *runSummary) addQuality(quality string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if quality == "" {
+		quality = "unknown"
+	}
+	if rs.Quality == nil {
+		rs.Quality = make(map[string]int)
+	}
+	rs.Quality[quality]++
+}
+
+// addSkip records in the summary that the item at location was skipped.
+func (rs *runSummary) addSkip(location string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.Skipped++
+	rs.SkippedItems = append(rs.SkippedItems, location)
+}
+
+// addNonMedia records in the summary that the page at location, met among
+// the items, is not a photo or a video, for the given reason.
+func (rs *runSummary) addNonMedia(location, reason string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.Skipped++
+	rs.NonMedia = append(rs.NonMedia, itemFailure{Item: location, Error: reason})
+}
+
+// warn records msg in the warnings of the summary, and logs it.
+func (rs *runSummary) warn(msg string) {
+	log.Printf("WARNING: %v", msg)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.Warnings = append(rs.Warnings, msg)
+}
+
+// addFailure records in the summary that the item at location could not be
+// downloaded because of err.
+func (rs *runSummary) addFailure(location string, err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.Failed++
+	counters.Add("failures", 1)
+	rs.Failures = append(rs.Failures, itemFailure{Item: location, Error: err.Error()})
+}
+
+// writeSummary completes the run summary with runErr, the error that ended
+// the run if any, and writes it to run-summary.json in the state directory.
+func (s *Session) writeSummary(runErr error) error {
+	rs := &s.summary
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.End = time.Now()
+	rs.Duration = rs.End.Sub(rs.Start).Round(time.Second).String()
+	if runErr != nil {
+		rs.Error = runErr.Error()
+	}
+	data, err := json.MarshalIndent(rs, "", "\t")
+	if err != nil {
+		return err
+	}
+	// write to a temp file first, so readers never see a partial summary.
+	path := filepath.Join(s.stateDir, summaryFile)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,58 @@
+//go:build !darwin && !freebsd && !linux && !windows
+// +build !darwin,!freebsd,!linux,!windows
+
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pauseSignal and resumeSignal are not available on this platform, where only
+// the pause file works.
+var pauseSignal, resumeSignal os.Signal
+
+// diskFree is not implemented on this platform.
+func diskFree(dir string) (int64, error) {
+	return 0, errors.New("checking free disk space not supported on this platform")
+}
+
+// tryLock has no advisory locks on this platform, so the lock is held while
+// f contains the PID of another process, which unlockDlDir clears.
+func tryLock(f *os.File) (bool, error) {
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return false, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return err != nil || pid == os.Getpid(), nil
+}
+
+// staleLockHint is added to the error of tryLock failing, as the PID of a run
+// that crashed stays in the lock file, which then has to be removed by hand.
+const staleLockHint = ", and remove it if that process is no longer running"
+
+// diskFull is not implemented on this platform, as the errors of a full disk
+// are not the same everywhere.
+func diskFull(err error) bool {
+	return false
+}
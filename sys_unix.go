@@ -0,0 +1,57 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// pauseSignal and resumeSignal pause and resume the crawl.
+var pauseSignal, resumeSignal os.Signal = syscall.SIGUSR1, syscall.SIGUSR2
+
+// diskFree returns the number of bytes available to unprivileged users on the
+// filesystem holding dir.
+func diskFree(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// tryLock takes an exclusive advisory lock on f, without waiting. It reports
+// false if another process holds it.
+func tryLock(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// staleLockHint is empty, as the lock goes away with the process holding it.
+const staleLockHint = ""
+
+// diskFull reports whether err is, or wraps, the error of a full disk.
+func diskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/chromedp/chromedp"
+)
+
+// tabPool keeps, with -tabpool, a few tabs with Google Photos already loaded,
+// for the items that are downloaded in a tab of their own, so they do not pay
+// the cold load of a new tab each time.
+type tabPool struct {
+	mu    sync.Mutex
+	ready []tab
+	// warming is the number of tabs being loaded.
+	warming int
+	// closed is set by closeTabs, after which the tabs that finish
+	// loading are closed rather than kept.
+	closed bool
+}
+
+type tab struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newTab opens a new tab, in the browser of parent, with the listeners of
+// listenTarget. It still needs the actions of prepareTarget before its first
+// navigation.
+func (s *Session) newTab(parent context.Context) tab {
+	ctx, cancel := chromedp.NewContext(parent)
+	s.listenTarget(ctx)
+	return tab{ctx: ctx, cancel: cancel}
+}
+
+// fillTabs opens, in the background, as many tabs as needed for the pool to
+// have *tabPoolFlag of them, and loads Google Photos in them.
+func (s *Session) fillTabs() {
+	p := &s.tabs
+	p.mu.Lock()
+	n := *tabPoolFlag - len(p.ready) - p.warming
+	if *lowMemFlag || p.closed {
+		n = 0
+	}
+	if n > 0 {
+		p.warming += n
+	}
+	p.mu.Unlock()
+	for i := 0; i < n; i++ {
+		go func() {
+			t := s.newTab(s.ctx)
+			err := chromedp.Run(t.ctx,
+				s.prepareTarget(),
+				chromedp.Navigate(s.photosURL),
+				chromedp.WaitReady("body", chromedp.ByQuery),
+			)
+			p.mu.Lock()
+			p.warming--
+			keep := err == nil && !p.closed
+			if keep {
+				p.ready = append(p.ready, t)
+			}
+			p.mu.Unlock()
+			if !keep {
+				t.cancel()
+			}
+			if err != nil && *verboseFlag {
+				log.Printf("Could not warm up a tab: %v", err)
+			}
+		}()
+	}
+}
+
+// takeTab returns a tab of the pool, or a new one, in the browser of ctx, if
+// none is ready, and refills the pool. The caller closes the tab, with its
+// cancel, when done.
+func (s *Session) takeTab(ctx context.Context) tab {
+	p := &s.tabs
+	p.mu.Lock()
+	var t tab
+	ok := len(p.ready) > 0
+	if ok {
+		t = p.ready[len(p.ready)-1]
+		p.ready = p.ready[:len(p.ready)-1]
+	}
+	p.mu.Unlock()
+	if !ok {
+		t = s.newTab(ctx)
+	}
+	s.fillTabs()
+	return t
+}
+
+// closeTabs closes the tabs of the pool, and those still loading when they
+// are done.
+func (s *Session) closeTabs() {
+	p := &s.tabs
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, t := range p.ready {
+		t.cancel()
+	}
+	p.ready = nil
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mediaFile is a photo or video file, in a Takeout archive or among our
+// downloads, as considered for reconciliation.
+type mediaFile struct {
+	path string
+	// name is the original filename of the item, lowercased.
+	name string
+	size int64
+	// taken is when the photo or video was taken, if known.
+	taken time.Time
+	hash  string
+	// matched is whether the file has a counterpart on the other side.
+	matched bool
+}
+
+// sum returns the SHA-256 of the contents of f, computing it the first time.
+func (f *mediaFile) sum() (string, error) {
+	if f.hash != "" {
+		return f.hash, nil
+	}
+	r, err := os.Open(f.path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	f.hash = fmt.Sprintf("%x", h.Sum(nil))
+	return f.hash, nil
+}
+
+// takeoutMetadata is the part we use of the JSON file that Takeout writes next
+// to each photo or video.
+type takeoutMetadata struct {
+	Title          string `json:"title"`
+	PhotoTakenTime struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"photoTakenTime"`
+}
+
+// takeoutFiles returns the photos and videos of the unpacked Takeout archive
+// in dir, with, when found, the original filename and taken time from their
+// JSON metadata.
+func takeoutFiles(dir string) ([]*mediaFile, error) {
+	var files []*mediaFile
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || strings.EqualFold(filepath.Ext(path), ".json") || strings.HasPrefix(fi.Name(), ".") {
+			return nil
+		}
+		f := &mediaFile{path: path, name: strings.ToLower(fi.Name()), size: fi.Size()}
+		for _, metaPath := range []string{path + ".json", path + ".supplemental-metadata.json"} {
+			data, err := ioutil.ReadFile(metaPath)
+			if err != nil {
+				continue
+			}
+			var meta takeoutMetadata
+			if err := json.Unmarshal(data, &meta); err != nil {
+				return fmt.Errorf("%v: %v", metaPath, err)
+			}
+			if meta.Title != "" {
+				f.name = strings.ToLower(meta.Title)
+			}
+			if sec, err := strconv.ParseInt(meta.PhotoTakenTime.Timestamp, 10, 64); err == nil {
+				f.taken = time.Unix(sec, 0).UTC()
+			}
+			break
+		}
+		files = append(files, f)
+		return nil
+	})
+	return files, err
+}
+
+// downloadedFiles returns the files of the items we downloaded, according to
+// the ledger and to what is on disk.
+func (s *Session) downloadedFiles() ([]*mediaFile, error) {
+	seen := make(map[string]bool)
+	var files []*mediaFile
+	add := func(path string) error {
+		if seen[path] {
+			return nil
+		}
+		seen[path] = true
+		fi, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		files = append(files, &mediaFile{path: path, name: strings.ToLower(filepath.Base(path)), size: fi.Size()})
+		return nil
+	}
+	entries, err := loadLedger(s.stateDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		for _, f := range e.Files {
+			if err := add(filepath.Join(s.dlDir, filepath.FromSlash(f))); err != nil {
+				return nil, err
+			}
+		}
+	}
+	items, err := localItems(s.dlDir)
+	if err != nil {
+		return nil, err
+	}
+	for id := range items {
+		dirEntries, err := ioutil.ReadDir(filepath.Join(s.dlDir, id))
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range dirEntries {
+			if v.IsDir() || v.Name() == metadataFile {
+				continue
+			}
+			if err := add(filepath.Join(s.dlDir, id, v.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// reconcileTakeout matches the files of the unpacked Takeout archive in dir
+// with our downloads, and prints the files that are missing on either side.
+// Files are matched by name and size first, then by contents. It returns an
+// exitPartial error if anything is missing.
+func (s *Session) reconcileTakeout(dir string) error {
+	takeout, err := takeoutFiles(dir)
+	if err != nil {
+		return err
+	}
+	local, err := s.downloadedFiles()
+	if err != nil {
+		return err
+	}
+	bySize := make(map[int64][]*mediaFile)
+	for _, f := range local {
+		bySize[f.size] = append(bySize[f.size], f)
+	}
+	match := func(t *mediaFile) (bool, error) {
+		candidates := bySize[t.size]
+		for _, l := range candidates {
+			if !l.matched && l.name == t.name {
+				l.matched, t.matched = true, true
+				return true, nil
+			}
+		}
+		// renamed, e.g. with a suffix by Takeout or by -both.
+		for _, l := range candidates {
+			if l.matched {
+				continue
+			}
+			lsum, err := l.sum()
+			if err != nil {
+				return false, err
+			}
+			tsum, err := t.sum()
+			if err != nil {
+				return false, err
+			}
+			if lsum == tsum {
+				l.matched, t.matched = true, true
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	var notLocal, notTakeout []*mediaFile
+	for _, t := range takeout {
+		ok, err := match(t)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			notLocal = append(notLocal, t)
+		}
+	}
+	for _, l := range local {
+		if !l.matched {
+			notTakeout = append(notTakeout, l)
+		}
+	}
+
+	fmt.Printf("In Takeout:  %d files\n", len(takeout))
+	fmt.Printf("Downloaded:  %d files\n", len(local))
+	fmt.Printf("Matched:     %d files\n", len(takeout)-len(notLocal))
+	printMediaFiles("In Takeout, but not downloaded", notLocal)
+	printMediaFiles("Downloaded, but not in Takeout", notTakeout)
+	if len(notLocal)+len(notTakeout) > 0 {
+		return exitErrorf(exitPartial, "%d files missing from %v, and %d from the Takeout archive", len(notLocal), s.dlDir, len(notTakeout))
+	}
+	return nil
+}
+
+// printMediaFiles prints title, and the paths of files, sorted, with their
+// taken time when known.
+func printMediaFiles(title string, files []*mediaFile) {
+	if len(files) == 0 {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	fmt.Printf("%s: %d files\n", title, len(files))
+	for _, f := range files {
+		if f.taken.IsZero() {
+			fmt.Printf("\t%v\n", f.path)
+			continue
+		}
+		fmt.Printf("\t%v\t%v\n", f.path, f.taken.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// minTick is the first wait of the polls, which then double it at each
+// attempt, up to tick. Most waits are over well before tick, so we do not want
+// to pay a whole tick each time, but we do not want to hammer a page that is
+// slow either.
+const minTick = 50 * time.Millisecond
+
+// backoff returns the successive waits of a poll: minTick, doubled each
+// time, up to tick. Its zero value is ready to use.
+type backoff struct {
+	next time.Duration
+}
+
+// wait returns how long to wait before the next attempt.
+func (b *backoff) wait() time.Duration {
+	if b.next == 0 {
+		b.next = minTick
+	}
+	d := b.next
+	if b.next *= 2; b.next > tick {
+		b.next = tick
+	}
+	if d > tick {
+		d = tick
+	}
+	return d
+}
+
+// sleep waits before the next attempt.
+func (b *backoff) sleep() {
+	time.Sleep(b.wait())
+}
+
+// latencies measures how long the pages typically take to be ready after a
+// navigation, as an exponential moving average.
+var latencies struct {
+	sync.Mutex
+	avg time.Duration
+}
+
+// recordLatency records that a page took d to be ready.
+func recordLatency(d time.Duration) {
+	latencies.Lock()
+	defer latencies.Unlock()
+	if latencies.avg == 0 {
+		latencies.avg = d
+		return
+	}
+	latencies.avg = (7*latencies.avg + d) / 8
+}
+
+// settle returns how long to let a page that is ready settle, e.g. before
+// sending it key events: twice the typical latency, within minTick and
+// 2*tick, or 2*tick until it is known.
+func settle() time.Duration {
+	latencies.Lock()
+	avg := latencies.avg
+	latencies.Unlock()
+	d := 2 * avg
+	switch {
+	case avg == 0 || d > 2*tick:
+		return 2 * tick
+	case d < minTick:
+		return minTick
+	}
+	return d
+}
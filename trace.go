@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxSlowSteps is how many of the slowest steps are listed in the trace
+// summary.
+const maxSlowSteps = 10
+
+// traceStats are the timings of one kind of step.
+type traceStats struct {
+	count int
+	total time.Duration
+	max   time.Duration
+}
+
+// step is a traced step.
+type step struct {
+	name, detail string
+	d            time.Duration
+}
+
+// tracer records the timings of the steps, with -trace.
+var tracer struct {
+	mu    sync.Mutex
+	stats map[string]*traceStats
+	slow  []step // the slowest steps, slowest first
+}
+
+// traceStep starts timing the step name (e.g. "nav left"), about detail (e.g.
+// an item URL), if -trace is set. The returned func ends the step.
+func traceStep(name, detail string) func() {
+	if !*traceFlag {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		d := time.Since(start)
+		log.Printf("Trace: %s %s took %v", name, detail, d.Round(time.Millisecond))
+		tracer.mu.Lock()
+		defer tracer.mu.Unlock()
+		if tracer.stats == nil {
+			tracer.stats = make(map[string]*traceStats)
+		}
+		st, ok := tracer.stats[name]
+		if !ok {
+			st = &traceStats{}
+			tracer.stats[name] = st
+		}
+		st.count++
+		st.total += d
+		if d > st.max {
+			st.max = d
+		}
+		i := sort.Search(len(tracer.slow), func(i int) bool { return tracer.slow[i].d < d })
+		if i >= maxSlowSteps {
+			return
+		}
+		tracer.slow = append(tracer.slow, step{})
+		copy(tracer.slow[i+1:], tracer.slow[i:])
+		tracer.slow[i] = step{name: name, detail: detail, d: d}
+		if len(tracer.slow) > maxSlowSteps {
+			tracer.slow = tracer.slow[:maxSlowSteps]
+		}
+	}
+}
+
+// logTraceSummary logs, with -trace, the time spent in each kind of step, and
+// the slowest steps, since the previous summary.
+func logTraceSummary() {
+	if !*traceFlag {
+		return
+	}
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	var names []string
+	for name := range tracer.stats {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return tracer.stats[names[i]].total > tracer.stats[names[j]].total })
+	log.Printf("Trace summary, by total time:")
+	for _, name := range names {
+		st := tracer.stats[name]
+		log.Printf("\t%-20s %6d steps, total %v, average %v, max %v", name, st.count,
+			st.total.Round(time.Millisecond), (st.total / time.Duration(st.count)).Round(time.Millisecond), st.max.Round(time.Millisecond))
+	}
+	log.Printf("Slowest steps:")
+	for _, st := range tracer.slow {
+		log.Printf("\t%v\t%s %s", st.d.Round(time.Millisecond), st.name, st.detail)
+	}
+	tracer.stats = nil
+	tracer.slow = nil
+}
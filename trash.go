@@ -0,0 +1,119 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// deletionsFile is the name of the file, in the download directory, where
+// -trash records the downloaded items that were moved to the trash.
+const deletionsFile = "deletions.json"
+
+// deletion is an item of the deletions file.
+type deletion struct {
+	ID string `json:"id"`
+	// Files are the paths, relative to dlDir, of the files of the item, which
+	// are never removed by gphotos-cdp.
+	Files []string `json:"files"`
+	// Trashed is when the item was first seen in the trash.
+	Trashed time.Time `json:"trashed"`
+}
+
+// checkTrash, with -trash, records in the deletions file the downloaded items
+// that are now in the trash. Items are only ever added to the file, so it is a
+// journal of the deletions, for the users to propagate them as they see fit.
+func (s *Session) checkTrash(ctx context.Context) error {
+	if !*trashFlag {
+		return nil
+	}
+	var items []gridItem
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		if err := navigate(ctx, strings.TrimSuffix(s.photosURL, "/")+"/trash"); err != nil {
+			return err
+		}
+		// the grid fills itself asynchronously.
+		time.Sleep(2 * tick)
+		var err error
+		items, err = scrollCollect(ctx, gridItemsJS)
+		return err
+	})); err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dlDir, deletionsFile)
+	var deletions []deletion
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &deletions); err != nil {
+			return fmt.Errorf("invalid %v: %v", path, err)
+		}
+	}
+	// the items we trashed ourselves, with -free-up, are no deletions.
+	known, err := loadFreed(s.stateDir)
+	if err != nil {
+		return err
+	}
+	for _, d := range deletions {
+		known[d.ID] = true
+	}
+	entries, err := loadLedger(s.stateDir)
+	if err != nil {
+		return err
+	}
+	files := make(map[string][]string)
+	for _, e := range entries {
+		files[e.ID] = e.Files
+	}
+
+	added := 0
+	for _, it := range items {
+		id, err := itemID(it.Href)
+		if err != nil || known[id] || !s.downloaded[id] {
+			continue
+		}
+		known[id] = true
+		deletions = append(deletions, deletion{ID: id, Files: files[id], Trashed: time.Now()})
+		added++
+	}
+	if added == 0 {
+		return nil
+	}
+	log.Printf("%d downloaded items were moved to the trash, see %v", added, path)
+	data, err = json.MarshalIndent(deletions, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+)
+
+// unavailableJS returns the text of the visible dialog, alert, or toast
+// saying the currently viewed item cannot be downloaded, if any.
+const unavailableJS = `(function() {
+	const rx = /(can.t|cannot|couldn.t|unable to) (be )?download|download (is )?(unavailable|not available)|not available for download/i;
+	for (const el of document.querySelectorAll('[role="alert"], [role="status"], [role="dialog"], [role="alertdialog"], [aria-live]')) {
+		const r = el.getBoundingClientRect();
+		if (r.width === 0 || r.height === 0) {
+			continue;
+		}
+		const text = (el.innerText || "").trim();
+		if (rx.test(text)) {
+			return text;
+		}
+	}
+	return "";
+})()`
+
+// unavailableError is returned when Google Photos says an item cannot be
+// downloaded.
+type unavailableError struct {
+	// reason is the class of the item, as told by unavailableReason.
+	reason string
+	text   string
+}
+
+func (e unavailableError) Error() string {
+	return fmt.Sprintf("download unavailable (%v): %q", e.reason, e.text)
+}
+
+// unavailableReason classifies the item from text, the message of Google
+// Photos saying it cannot be downloaded.
+func unavailableReason(text string) string {
+	text = strings.ToLower(text)
+	switch {
+	case strings.Contains(text, "print"):
+		return "print order"
+	case strings.Contains(text, "processing"):
+		return "still processing"
+	case strings.Contains(text, "shared") || strings.Contains(text, "owner") || strings.Contains(text, "partner"):
+		return "third-party item"
+	}
+	return "unavailable"
+}
+
+// nonMediaJS returns, if the current page of the viewer is not a photo or a
+// video, but e.g. a print order or a photo book project, what it is, or the
+// empty string otherwise.
+const nonMediaJS = `(function() {
+	const path = location.pathname;
+	if (/\/(prints?|printorders?|store|canvas)(\/|$)/i.test(path)) {
+		return "print order";
+	}
+	if (/\/(photo)?books?(\/|$)/i.test(path)) {
+		return "photo book";
+	}
+	if (!path.includes("/photo/")) {
+		return "not media";
+	}
+	for (const el of document.querySelectorAll('h1, h2, [role="heading"]')) {
+		const r = el.getBoundingClientRect();
+		if (r.width === 0 || r.height === 0) {
+			continue;
+		}
+		const text = (el.innerText || "").trim();
+		if (/^(order details|print order|(canvas|photo) prints?)/i.test(text)) {
+			return "print order";
+		}
+		if (/^photo book/i.test(text)) {
+			return "photo book";
+		}
+	}
+	return "";
+})()`
+
+// nonMediaError is returned for the pages, met among the items, that are not
+// photos or videos, and hence cannot be downloaded.
+type nonMediaError struct {
+	reason string
+}
+
+func (e nonMediaError) Error() string {
+	return fmt.Sprintf("not a photo or video (%v)", e.reason)
+}
+
+// nonMediaReason returns, if the current page of the viewer is not a photo or
+// a video, what it is, or the empty string otherwise.
+func nonMediaReason(ctx context.Context) (string, error) {
+	var reason string
+	if err := chromedp.Evaluate(nonMediaJS, &reason).Do(ctx); err != nil {
+		return "", err
+	}
+	return reason, nil
+}
+
+// checkNonMedia returns a nonMediaError if the current page of the viewer, at
+// location, is not a photo or a video, so it is skipped right away, instead of
+// timing out waiting for its download.
+func checkNonMedia(ctx context.Context, location string) error {
+	reason, err := nonMediaReason(ctx)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		return nil
+	}
+	return itemErrorf("%v: %w", location, nonMediaError{reason: reason})
+}
+
+// checkUnavailable returns an unavailableError if Google Photos shows that
+// the currently viewed item, at location, cannot be downloaded, after
+// dismissing the message.
+func checkUnavailable(ctx context.Context, location string) error {
+	var text string
+	if err := chromedp.Evaluate(unavailableJS, &text).Do(ctx); err != nil {
+		return err
+	}
+	if text == "" {
+		return nil
+	}
+	log.Printf("Google Photos cannot download %v: %q", location, text)
+	if err := dismissDialogs(ctx); err != nil {
+		return err
+	}
+	// for the toasts, which have no dismiss button.
+	if err := keyEvent(ctx, kb.Escape, focusAny); err != nil {
+		return err
+	}
+	return itemErrorf("%w", unavailableError{reason: unavailableReason(text), text: text})
+}
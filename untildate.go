@@ -0,0 +1,53 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// untilDate is the date parsed from -until-date, or the zero time.
+var untilDate time.Time
+
+// pastUntilDate reports whether the currently viewed item, at location, was
+// taken past -until-date, i.e. on or after it when going from the oldest, or
+// before it when going from the newest, in which case the crawl is over. The
+// date is read from the info panel, in the time zone of the item.
+func (s *Session) pastUntilDate(ctx context.Context, location string) (bool, error) {
+	if untilDate.IsZero() {
+		return false, nil
+	}
+	lines, err := infoPanelText(ctx)
+	if err != nil {
+		return false, err
+	}
+	taken := parseTaken(lines)
+	if taken.IsZero() {
+		if *verboseFlag {
+			log.Printf("No date found for %v, not checking -until-date", location)
+		}
+		return false, nil
+	}
+	date := taken.Format(startDateLayout)
+	until := untilDate.Format(startDateLayout)
+	if newestFirst() {
+		return date < until, nil
+	}
+	return date >= until, nil
+}
@@ -0,0 +1,449 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxUploadAttempts is how many times we try to upload a file, backing off
+// exponentially between attempts, before giving up.
+const maxUploadAttempts = 5
+
+// uploader stores files on remote storage.
+type uploader interface {
+	// upload stores the file at localPath as key, a slash separated path
+	// relative to the destination given to -upload.
+	upload(ctx context.Context, localPath, key string) error
+}
+
+// parseUpload returns the uploader for the -upload destination. The requests
+// to S3 are made with client.
+func parseUpload(dest string, client *http.Client) (uploader, error) {
+	switch {
+	case strings.HasPrefix(dest, "s3://"):
+		bucketPrefix := strings.TrimPrefix(dest, "s3://")
+		parts := strings.SplitN(bucketPrefix, "/", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("no bucket in -upload %q", dest)
+		}
+		u := &s3Uploader{
+			bucket:    parts[0],
+			accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			token:     os.Getenv("AWS_SESSION_TOKEN"),
+			region:    os.Getenv("AWS_REGION"),
+			endpoint:  os.Getenv("AWS_ENDPOINT_URL"),
+			client:    client,
+		}
+		if len(parts) == 2 {
+			u.prefix = strings.Trim(parts[1], "/")
+		}
+		if u.accessKey == "" || u.secretKey == "" {
+			return nil, errors.New("-upload to s3 needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		if u.region == "" {
+			u.region = "us-east-1"
+		}
+		if u.endpoint == "" {
+			u.endpoint = "https://s3." + u.region + ".amazonaws.com"
+		}
+		u.endpoint = strings.TrimSuffix(u.endpoint, "/")
+		return u, nil
+	case strings.HasPrefix(dest, "rclone:"):
+		remote := strings.TrimPrefix(dest, "rclone:")
+		if !strings.Contains(remote, ":") {
+			return nil, fmt.Errorf("-upload %q is not of the form rclone:remote:path", dest)
+		}
+		if _, err := exec.LookPath("rclone"); err != nil {
+			return nil, err
+		}
+		return rcloneUploader{remote: strings.TrimSuffix(remote, "/")}, nil
+	}
+	return nil, fmt.Errorf("unsupported -upload %q, want s3://bucket/prefix or rclone:remote:path", dest)
+}
+
+// rcloneUploader uploads with the rclone program.
+type rcloneUploader struct {
+	remote string // e.g. "gdrive:photos"
+}
+
+func (u rcloneUploader) upload(ctx context.Context, localPath, key string) error {
+	dest := u.remote + "/" + key
+	if strings.HasSuffix(u.remote, ":") {
+		dest = u.remote + key
+	}
+	out, err := exec.CommandContext(ctx, "rclone", "copyto", localPath, dest).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rclone copyto %v %v: %v: %s", localPath, dest, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// s3PartSize is the size of the parts of the multipart uploads to S3, which
+// the files larger than it are uploaded with, as a single PUT is limited to
+// 5 GB.
+const s3PartSize = 64 << 20
+
+// s3Uploader uploads to an S3 (or compatible) bucket, with a single signed PUT
+// per file, or a multipart upload for the files larger than s3PartSize.
+type s3Uploader struct {
+	bucket, prefix              string
+	accessKey, secretKey, token string
+	region                      string
+	endpoint                    string // scheme and host, e.g. https://s3.eu-west-3.amazonaws.com
+	client                      *http.Client
+}
+
+func (u *s3Uploader) upload(ctx context.Context, localPath, key string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	objectPath := "/" + u.bucket + "/" + s3Escape(path.Join(u.prefix, key))
+	if fi.Size() > s3PartSize {
+		err = u.uploadParts(ctx, f, fi.Size(), objectPath)
+	} else {
+		_, _, err = u.do(ctx, "PUT", objectPath, nil, f, fi.Size())
+	}
+	if err != nil {
+		return fmt.Errorf("uploading %v to s3://%v%v: %v", localPath, u.bucket, objectPath, err)
+	}
+	return nil
+}
+
+// uploadParts uploads the size bytes of f to objectPath with a multipart
+// upload, in parts of s3PartSize. The upload is aborted if a part fails, so
+// the bucket does not keep its parts.
+func (u *s3Uploader) uploadParts(ctx context.Context, f *os.File, size int64, objectPath string) error {
+	_, body, err := u.do(ctx, "POST", objectPath, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(body, &initiated); err != nil {
+		return fmt.Errorf("could not read the multipart upload ID: %v", err)
+	}
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	err = func() error {
+		for off, n := int64(0), 1; off < size; off, n = off+s3PartSize, n+1 {
+			partSize := int64(s3PartSize)
+			if size-off < partSize {
+				partSize = size - off
+			}
+			query := url.Values{"partNumber": {fmt.Sprint(n)}, "uploadId": {initiated.UploadID}}
+			header, _, err := u.do(ctx, "PUT", objectPath, query, io.NewSectionReader(f, off, partSize), partSize)
+			if err != nil {
+				return fmt.Errorf("part %d: %v", n, err)
+			}
+			complete.Parts = append(complete.Parts, part{PartNumber: n, ETag: header.Get("ETag")})
+		}
+		data, err := xml.Marshal(complete)
+		if err != nil {
+			return err
+		}
+		_, body, err := u.do(ctx, "POST", objectPath, url.Values{"uploadId": {initiated.UploadID}}, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return err
+		}
+		// the completion can fail after the 200 OK has been sent.
+		if bytes.Contains(body, []byte("<Error>")) {
+			return fmt.Errorf("completing the multipart upload: %s", body)
+		}
+		return nil
+	}()
+	if err != nil {
+		if _, _, abortErr := u.do(ctx, "DELETE", objectPath, url.Values{"uploadId": {initiated.UploadID}}, nil, 0); abortErr != nil {
+			log.Printf("Error aborting the multipart upload to %v: %v", objectPath, abortErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// do sends the signed request with method, for objectPath and query, with
+// the size bytes of body as the payload. It returns the headers and the body
+// of the response, or an error if its status is not a success.
+func (u *s3Uploader) do(ctx context.Context, method, objectPath string, query url.Values, body io.Reader, size int64) (http.Header, []byte, error) {
+	rawQuery := s3CanonicalQuery(query)
+	target := u.endpoint + objectPath
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = size
+	u.sign(req, objectPath, time.Now().UTC())
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return nil, nil, fmt.Errorf("%v: %s", resp.Status, data)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Header, data, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to req, whose query must be
+// in the canonical form of s3CanonicalQuery. The payload is not signed, so it
+// can be streamed.
+func (u *s3Uploader) sign(req *http.Request, canonicalPath string, now time.Time) {
+	const payloadHash = "UNSIGNED-PAYLOAD"
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if u.token != "" {
+		req.Header.Set("x-amz-security-token", u.token)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = req.Header.Get(k)
+		}
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + u.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := []byte("AWS4" + u.secretKey)
+	for _, s := range []string{day, u.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		u.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Escape escapes the object key p as S3 expects it in a canonical request:
+// everything but the unreserved characters and the slashes is percent-encoded.
+func s3Escape(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery returns query as S3 expects it in a canonical request: the
+// parameters sorted by name, and escaped like s3Escape, slashes included.
+func s3CanonicalQuery(query url.Values) string {
+	var names []string
+	for k := range query {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	escape := func(s string) string {
+		return strings.Replace(s3Escape(s), "/", "%2F", -1)
+	}
+	var params []string
+	for _, k := range names {
+		for _, v := range query[k] {
+			params = append(params, escape(k)+"="+escape(v))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// uploadQueue uploads the downloaded files in the background, in the order
+// they were downloaded.
+type uploadQueue struct {
+	up    uploader
+	dlDir string
+
+	files    chan string
+	done     chan struct{}
+	mu       sync.Mutex
+	err      error           // first file that could not be uploaded
+	uploaded map[string]bool // local paths of the uploaded files
+}
+
+// start starts uploading the queued files. It must be followed by a call to
+// wait.
+func (q *uploadQueue) start(ctx context.Context) {
+	if q == nil {
+		return
+	}
+	q.err = nil
+	q.uploaded = nil
+	q.files = make(chan string, 1000)
+	q.done = make(chan struct{})
+	go func() {
+		defer close(q.done)
+		for localPath := range q.files {
+			if err := q.uploadFile(ctx, localPath); err != nil {
+				q.mu.Lock()
+				if q.err == nil {
+					q.err = err
+				}
+				q.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// add queues the file at localPath for upload. It returns an error if a
+// previous upload failed for good.
+func (q *uploadQueue) add(localPath string) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	err := q.err
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	q.files <- localPath
+	return nil
+}
+
+// wait waits for all the queued files to be uploaded, and returns the first
+// upload failure.
+func (q *uploadQueue) wait() error {
+	if q == nil || q.files == nil {
+		return nil
+	}
+	close(q.files)
+	<-q.done
+	q.files = nil
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// isUploaded reports whether the file at localPath was uploaded.
+func (q *uploadQueue) isUploaded(localPath string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.uploaded[localPath]
+}
+
+// uploadFile uploads the file at localPath, retrying with an exponential
+// backoff, and removes it afterwards with -upload-delete.
+func (q *uploadQueue) uploadFile(ctx context.Context, localPath string) error {
+	rel, err := filepath.Rel(q.dlDir, localPath)
+	if err != nil {
+		return err
+	}
+	key := filepath.ToSlash(rel)
+	backoff := 5 * time.Second
+	for i := 1; ; i++ {
+		err = q.up.upload(ctx, localPath, key)
+		if err == nil {
+			break
+		}
+		if i == maxUploadAttempts || ctx.Err() != nil {
+			return fmt.Errorf("could not upload %v after %d attempts: %w", localPath, i, err)
+		}
+		log.Printf("Upload of %v failed, retrying in %v: %v", localPath, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+	if *verboseFlag {
+		log.Printf("Uploaded %v", localPath)
+	}
+	q.mu.Lock()
+	if q.uploaded == nil {
+		q.uploaded = make(map[string]bool)
+	}
+	q.uploaded[localPath] = true
+	q.mu.Unlock()
+	if !*uploadDeleteFlag {
+		return nil
+	}
+	return os.Remove(localPath)
+}
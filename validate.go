@@ -0,0 +1,215 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// corruptDir is the directory, in dlDir, where the files of the items that
+// fail the -validate checks are moved.
+const corruptDir = "corrupt"
+
+// validateItem checks, with -validate, the files of the item id that were
+// just downloaded. If one of them is corrupt, all of them are moved to
+// corruptDir, and it returns an item error, so the item is retried.
+func (s *Session) validateItem(id string, paths []string) error {
+	if !*validateFlag {
+		return nil
+	}
+	var verr error
+	for _, path := range paths {
+		if err := validateFile(path); err != nil {
+			verr = fmt.Errorf("%v is corrupt: %v", filepath.Base(path), err)
+			break
+		}
+	}
+	if verr == nil {
+		return nil
+	}
+	dir := filepath.Join(s.dlDir, corruptDir, id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Rename(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+			return err
+		}
+	}
+	// only removed if empty, i.e. unless it has files of previous runs.
+	os.Remove(filepath.Join(s.dlDir, id))
+	log.Printf("Moved the files of %v to %v", id, dir)
+	counters.Add("corrupt", 1)
+	return itemErrorf("%v", verr)
+}
+
+// validateFile checks that the file at path is a complete and valid image or
+// video. JPEG, PNG, and GIF images are decoded, and the box structure of HEIC
+// images and MP4 and QuickTime videos is walked, with the duration of the
+// videos checked too. Other kinds of files are not checked.
+func validateFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		return errors.New("empty file")
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		_, _, err := image.Decode(f)
+		return err
+	case ".heic", ".heif":
+		boxes, err := readBoxes(f, 0, fi.Size())
+		if err != nil {
+			return err
+		}
+		if boxes[0].typ != "ftyp" {
+			return errors.New("no ftyp box")
+		}
+		if _, ok := findBox(boxes, "meta"); !ok {
+			return errors.New("no meta box")
+		}
+		return nil
+	case ".mp4", ".m4v", ".mov", ".3gp":
+		boxes, err := readBoxes(f, 0, fi.Size())
+		if err != nil {
+			return err
+		}
+		moov, ok := findBox(boxes, "moov")
+		if !ok {
+			return errors.New("no moov box")
+		}
+		children, err := readBoxes(f, moov.data, moov.end)
+		if err != nil {
+			return fmt.Errorf("moov: %v", err)
+		}
+		mvhd, ok := findBox(children, "mvhd")
+		if !ok {
+			return errors.New("no mvhd box")
+		}
+		duration, err := mvhdDuration(f, mvhd)
+		if err != nil {
+			return err
+		}
+		if duration == 0 {
+			return errors.New("zero duration")
+		}
+		return nil
+	}
+	return nil
+}
+
+// box is an ISO base media file format box, whose data starts at the offset
+// data, and which ends at the offset end.
+type box struct {
+	typ       string
+	data, end int64
+}
+
+// readBoxes returns the boxes of f between the offsets start and end, and
+// fails if they do not fill exactly that range, e.g. when the file is
+// truncated.
+func readBoxes(f io.ReaderAt, start, end int64) ([]box, error) {
+	var boxes []box
+	for off := start; off < end; {
+		if end-off < 8 {
+			return nil, fmt.Errorf("truncated box header at %d", off)
+		}
+		var hdr [16]byte
+		if _, err := f.ReadAt(hdr[:8], off); err != nil {
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		b := box{typ: string(hdr[4:8]), data: off + 8}
+		switch size {
+		case 0:
+			// up to the end
+			size = end - off
+		case 1:
+			if end-off < 16 {
+				return nil, fmt.Errorf("truncated box header at %d", off)
+			}
+			if _, err := f.ReadAt(hdr[8:16], off+8); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			b.data += 8
+		}
+		if size < b.data-off || off+size > end {
+			return nil, fmt.Errorf("%q box at %d of size %d does not fit in %d bytes", b.typ, off, size, end-start)
+		}
+		b.end = off + size
+		boxes = append(boxes, b)
+		off = b.end
+	}
+	if len(boxes) == 0 {
+		return nil, errors.New("no box")
+	}
+	return boxes, nil
+}
+
+// findBox returns the first of boxes of type typ.
+func findBox(boxes []box, typ string) (box, bool) {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// mvhdDuration returns the duration, in units of its time scale, recorded in
+// the mvhd box b.
+func mvhdDuration(f io.ReaderAt, b box) (uint64, error) {
+	var data [32]byte
+	n := b.end - b.data
+	if n > int64(len(data)) {
+		n = int64(len(data))
+	}
+	if _, err := f.ReadAt(data[:n], b.data); err != nil {
+		return 0, err
+	}
+	// version and flags, then the creation and modification times, and
+	// the time scale, before the duration.
+	if data[0] == 1 {
+		if n < 32 {
+			return 0, errors.New("truncated mvhd box")
+		}
+		return binary.BigEndian.Uint64(data[24:32]), nil
+	}
+	if n < 20 {
+		return 0, errors.New("truncated mvhd box")
+	}
+	return uint64(binary.BigEndian.Uint32(data[16:20])), nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// verify checks, for the verify command, that the files of all the items of
+// the ledger are safely stored, and with -validate complete, and with
+// -takeout, matches them with the Takeout archive. It prints the items that
+// fail, and returns an exitPartial error if there are any.
+func (s *Session) verify() error {
+	entries, err := loadLedger(s.stateDir)
+	if err != nil {
+		return err
+	}
+	failed := make(map[string]error)
+	for _, e := range entries {
+		err := verifyFiles(s.dlDir, e.Files)
+		if err == nil && *validateFlag {
+			for _, f := range e.Files {
+				if err = validateFile(filepath.Join(s.dlDir, filepath.FromSlash(f))); err != nil {
+					err = fmt.Errorf("%v: %v", f, err)
+					break
+				}
+			}
+		}
+		if err != nil {
+			failed[e.ID] = err
+		}
+	}
+	fmt.Printf("In the ledger: %d items\n", len(entries))
+	fmt.Printf("Verified:      %d items\n", len(entries)-len(failed))
+	if len(failed) > 0 {
+		ids := make([]string, 0, len(failed))
+		for id := range failed {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		fmt.Printf("Failed: %d items\n", len(failed))
+		for _, id := range ids {
+			fmt.Printf("\t%v\t%v\n", id, failed[id])
+		}
+	}
+	if *takeoutFlag != "" {
+		if err := s.reconcileTakeout(*takeoutFlag); err != nil && len(failed) == 0 {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return exitErrorf(exitPartial, "%d items of the ledger failed verification", len(failed))
+	}
+	return nil
+}
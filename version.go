@@ -0,0 +1,75 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/chromedp"
+)
+
+// The range of the major versions of Chrome known to work. The older ones
+// lack the download events of the Page domain, and on the newer ones, where
+// these events are deprecated, neither they nor the keyboard shortcuts of the
+// viewer were checked.
+const (
+	minChromeVersion = 80
+	maxChromeVersion = 130
+)
+
+// chromeVersionRx matches the major version in the product of the browser,
+// e.g. "HeadlessChrome/83.0.4103.116".
+var chromeVersionRx = regexp.MustCompile(`/(\d+)\.`)
+
+// checkChromeVersion warns when the version of the browser is out of the
+// known-good range, or, with -strict, returns an error. It only checks once
+// per session.
+func (s *Session) checkChromeVersion(ctx context.Context) error {
+	if s.versionChecked {
+		return nil
+	}
+	s.versionChecked = true
+	// a browser, not a target, command.
+	_, product, _, _, _, err := browser.GetVersion().Do(cdp.WithExecutor(ctx, chromedp.FromContext(ctx).Browser))
+	if err != nil {
+		return err
+	}
+	if *verboseFlag {
+		log.Printf("Browser version: %v", product)
+	}
+	var msg string
+	if m := chromeVersionRx.FindStringSubmatch(product); m == nil {
+		msg = fmt.Sprintf("could not tell the major version of the browser, %v", product)
+	} else if major, _ := strconv.Atoi(m[1]); major < minChromeVersion {
+		msg = fmt.Sprintf("the browser, %v, is older than version %d, which gphotos-cdp needs", product, minChromeVersion)
+	} else if major > maxChromeVersion {
+		msg = fmt.Sprintf("the browser, %v, is newer than version %d, the most recent gphotos-cdp is known to work with", product, maxChromeVersion)
+	} else {
+		return nil
+	}
+	if *strictFlag {
+		return fmt.Errorf("%v, and -strict is set", msg)
+	}
+	s.summary.warn(msg)
+	return nil
+}
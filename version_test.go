@@ -0,0 +1,41 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestChromeVersionRx(t *testing.T) {
+	tests := []struct {
+		product string
+		want    string
+	}{
+		{"HeadlessChrome/83.0.4103.116", "83"},
+		{"Chrome/120.0.6099.109", "120"},
+		{"Chromium/79.0.3945.0", "79"},
+		{"Chrome", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if m := chromeVersionRx.FindStringSubmatch(tt.product); m != nil {
+			got = m[1]
+		}
+		if got != tt.want {
+			t.Errorf("major version of %q = %q, want %q", tt.product, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// maxViewerReloads is how many times in a row we reload an item whose viewer
+// shows an error, or nothing, before going on with it anyway, and relying on
+// the retries of its download.
+const maxViewerReloads = 2
+
+// The states of the viewer, as returned by viewerStateJS.
+const (
+	viewerOK    = "ok"
+	viewerError = "error" // e.g. "Can't load photo"
+	viewerBlank = "blank" // neither image nor video
+)
+
+// viewerStateJS returns whether the viewer shows an error message instead of
+// the current item, shows no image or video at all, or looks fine.
+const viewerStateJS = `(function() {
+	const visible = (el) => {
+		const r = el.getBoundingClientRect();
+		return r.width > 0 && r.height > 0;
+	};
+	const rx = /^(can.t|couldn.t|unable to) (load|play|show) (this )?(photo|video|item|image)|^something went wrong/i;
+	for (const el of document.querySelectorAll('[role="alert"], [role="status"], [aria-live], div, span')) {
+		if (el.children.length > 1 || !visible(el)) {
+			continue;
+		}
+		if (rx.test((el.innerText || "").trim())) {
+			return "error";
+		}
+	}
+	for (const el of document.querySelectorAll('video, [aria-label*="Play video" i]')) {
+		if (visible(el)) {
+			return "ok";
+		}
+	}
+	for (const el of document.querySelectorAll('img')) {
+		const r = el.getBoundingClientRect();
+		if (el.complete && (r.width > window.innerWidth / 2 || r.height > window.innerHeight / 2)) {
+			return "ok";
+		}
+	}
+	return "blank";
+})()`
+
+// viewerState returns the state of the viewer, giving it a few seconds to
+// render the item first.
+func viewerState(ctx context.Context) (string, error) {
+	var state string
+	var b backoff
+	for deadline := time.Now().Add(10 * tick); ; b.sleep() {
+		if err := chromedp.Evaluate(viewerStateJS, &state).Do(ctx); err != nil {
+			return "", err
+		}
+		if state != viewerBlank || time.Now().After(deadline) {
+			break
+		}
+	}
+	return state, nil
+}
+
+// checkViewer reloads the currently viewed item, up to maxViewerReloads times,
+// while the viewer shows an error, or nothing, as happens after transient load
+// failures.
+func checkViewer(ctx context.Context) error {
+	for reloads := 0; ; reloads++ {
+		state, err := viewerState(ctx)
+		if err != nil {
+			return err
+		}
+		if state == viewerOK {
+			return nil
+		}
+		// there is nothing to load in the first place.
+		if reason, err := nonMediaReason(ctx); err != nil {
+			return err
+		} else if reason != "" {
+			return nil
+		}
+		var location string
+		if err := chromedp.Location(&location).Do(ctx); err != nil {
+			return err
+		}
+		if reloads == maxViewerReloads {
+			log.Printf("The viewer still shows %v at %v, going on with it", state, location)
+			return nil
+		}
+		log.Printf("The viewer shows %v at %v, reloading it", state, location)
+		counters.Add("viewerReloads", 1)
+		if err := reloadItem(ctx, location); err != nil {
+			return err
+		}
+	}
+}
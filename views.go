@@ -0,0 +1,211 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The directories, in dlDir, of the views built with -views.
+const (
+	byDateDir  = "by-date"
+	byAlbumDir = "by-album"
+)
+
+// viewsFile is the name of the file, in dlDir, mapping the paths of the
+// files in the views to the paths of the files they link to, all relative to
+// dlDir.
+const viewsFile = "views.json"
+
+// reservedDir reports whether name, a directory in dlDir, is not an item
+// directory.
+func reservedDir(name string) bool {
+	return name == debugDir || name == corruptDir || name == partnerDir || name == byDateDir || name == byAlbumDir || strings.HasPrefix(name, ".")
+}
+
+// buildViews rebuilds, with -views, the by-date and by-album directories,
+// where the files of the items are linked, by year and month of capture, and
+// by album, with the albums recorded by -album-index. Files of different
+// items with the same name in a directory are all named with viewName, and
+// the mapping to the item files is recorded in viewsFile.
+func (s *Session) buildViews() error {
+	if *viewsFlag == "" {
+		return nil
+	}
+	link := os.Symlink
+	if *viewsFlag == "hardlink" {
+		link = os.Link
+	}
+	entries, err := loadLedger(s.stateDir)
+	if err != nil {
+		return err
+	}
+	files := make(map[string][]string)
+	for _, e := range entries {
+		files[e.ID] = e.Files
+	}
+
+	for _, dir := range []string{byDateDir, byAlbumDir} {
+		if err := os.RemoveAll(filepath.Join(s.dlDir, dir)); err != nil {
+			return err
+		}
+	}
+	// the items of each view directory, in order.
+	var dirs []string
+	dirItems := make(map[string][]string)
+	addItem := func(dir, id string) {
+		if _, ok := dirItems[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		dirItems[dir] = append(dirItems[dir], id)
+	}
+
+	for _, e := range entries {
+		taken, err := s.takenTime(e)
+		if err != nil {
+			log.Printf("Not adding %v to %v: %v", e.ID, byDateDir, err)
+			continue
+		}
+		dir := filepath.Join(s.dlDir, byDateDir, taken.Format("2006"), taken.Format("01"))
+		addItem(dir, e.ID)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(s.dlDir, albumsFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var albums []album
+		if err := json.Unmarshal(data, &albums); err != nil {
+			return fmt.Errorf("invalid %v: %v", albumsFile, err)
+		}
+		for _, a := range albums {
+			dir := filepath.Join(s.dlDir, byAlbumDir, albumDirName(a))
+			for _, id := range a.Items {
+				addItem(dir, id)
+			}
+		}
+	}
+
+	mapping := make(map[string]string)
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		// the number of items with a file of that name, ignoring the case,
+		// for the case-insensitive file systems.
+		names := make(map[string]int)
+		for _, id := range dirItems[dir] {
+			for _, f := range files[id] {
+				names[strings.ToLower(path.Base(f))]++
+			}
+		}
+		for _, id := range dirItems[dir] {
+			for _, f := range files[id] {
+				name := path.Base(f)
+				if names[strings.ToLower(name)] > 1 {
+					name = viewName(name, id)
+				}
+				target := filepath.Join(s.dlDir, filepath.FromSlash(f))
+				if *viewsFlag == "symlink" {
+					rel, err := filepath.Rel(dir, target)
+					if err != nil {
+						return err
+					}
+					target = rel
+				}
+				linkPath := filepath.Join(dir, name)
+				if err := link(target, linkPath); err != nil {
+					if os.IsExist(err) {
+						// the same item more than once in an album.
+						continue
+					}
+					return err
+				}
+				rel, err := filepath.Rel(s.dlDir, linkPath)
+				if err != nil {
+					return err
+				}
+				mapping[filepath.ToSlash(rel)] = f
+			}
+		}
+	}
+	data, err = json.MarshalIndent(mapping, "", "\t")
+	if err != nil {
+		return err
+	}
+	viewsPath := filepath.Join(s.dlDir, viewsFile)
+	tmpPath := viewsPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, viewsPath)
+}
+
+// viewName returns the name, in a view, of the file of the item id named
+// name, when other items have a file of the same name: name suffixed with a
+// short hash of id, which does not depend on the other items, so the name
+// stays the same across rebuilds.
+func viewName(name, id string) string {
+	sum := sha256.Sum256([]byte(id))
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "_" + hex.EncodeToString(sum[:4]) + ext
+}
+
+// takenTime returns when the item of e was taken, from its metadata sidecar
+// file if any, or from the modification time of its first file otherwise.
+func (s *Session) takenTime(e ledgerEntry) (time.Time, error) {
+	if data, err := ioutil.ReadFile(filepath.Join(s.dlDir, e.ID, metadataFile)); err == nil {
+		var info itemInfo
+		if err := json.Unmarshal(data, &info); err == nil && !info.Taken.IsZero() {
+			return info.Taken, nil
+		}
+	}
+	if len(e.Files) == 0 {
+		return time.Time{}, fmt.Errorf("no file")
+	}
+	fi, err := os.Stat(filepath.Join(s.dlDir, filepath.FromSlash(e.Files[0])))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// albumDirName returns the name of the directory of a in the by-album view:
+// its title, made safe as a file name, or its ID if it has no title.
+func albumDirName(a album) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(a.Title))
+	if name == "" || name == "." || name == ".." {
+		return a.ID
+	}
+	return name
+}
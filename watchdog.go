@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// maxStallRecoveries is how many times in a row we try to recover from a
+// stall on the same item, before giving up on the run.
+const maxStallRecoveries = 3
+
+// progressClock records when we last made progress, as reported by beat.
+type progressClock struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (c *progressClock) touch() {
+	c.mu.Lock()
+	c.last = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *progressClock) since() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.last)
+}
+
+// withWatchdog runs f with a context that is canceled if s makes no progress
+// (neither moving to another item, nor downloading) for -stalltimeout. It
+// returns an exitNavStall error in that case.
+func (s *Session) withWatchdog(ctx context.Context, f func(context.Context) error) error {
+	if *stallTimeoutFlag <= 0 {
+		return f(ctx)
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.clock.touch()
+	stalled := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if s.clock.since() > *stallTimeoutFlag {
+					close(stalled)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	err := f(wctx)
+	close(done)
+	select {
+	case <-stalled:
+		if ctx.Err() == nil {
+			return exitErrorf(exitNavStall, "no progress for %v", *stallTimeoutFlag)
+		}
+	default:
+	}
+	return err
+}
+
+// recoverStall writes a debug bundle about the stall err, and reloads the
+// item at location, so we can try again from there.
+func (s *Session) recoverStall(ctx context.Context, location string, err error) error {
+	log.Printf("Stalled at %v, reloading it: %v", location, err)
+	s.writeDebugBundle(ctx, err)
+	return reloadItem(ctx, location)
+}
+
+// reloadItem loads the item at location afresh in the viewer.
+func reloadItem(ctx context.Context, location string) error {
+	start := time.Now()
+	if err := chromedp.Run(ctx,
+		chromedp.Navigate(location),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+	); err != nil {
+		return err
+	}
+	recordLatency(time.Since(start))
+	// let the viewer settle, so it gets our key events.
+	time.Sleep(settle())
+	return dismissDialogs(ctx)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "time"
+
+// itemXattrs returns the extended attributes, without their namespace, to set
+// with -xattr on the files of the item with the given id, at location,
+// described by info if not nil.
+func itemXattrs(id, location string, info *itemInfo) map[string]string {
+	attrs := map[string]string{
+		"gphotos.id":  id,
+		"gphotos.url": location,
+	}
+	if info == nil {
+		return attrs
+	}
+	if !info.Taken.IsZero() {
+		attrs["gphotos.taken"] = info.Taken.Format(time.RFC3339)
+	}
+	if info.Description != "" {
+		attrs["gphotos.description"] = info.Description
+	}
+	return attrs
+}
+
+// setItemXattrs sets, with -xattr, the extended attributes of the item on
+// the files at paths.
+func setItemXattrs(paths []string, id, location string, info *itemInfo) error {
+	if !*xattrFlag {
+		return nil
+	}
+	attrs := itemXattrs(id, location, info)
+	for _, path := range paths {
+		for name, value := range attrs {
+			if err := setXattr(path, name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
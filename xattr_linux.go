@@ -0,0 +1,37 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// xattrSupported is whether setXattr is implemented on this platform.
+const xattrSupported = true
+
+// setXattr sets the extended attribute name, in the user namespace, of the
+// file at path.
+func setXattr(path, name, value string) error {
+	if err := syscall.Setxattr(path, "user."+name, []byte(value), 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: path, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2019 The Perkeep Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "io/ioutil"
+
+// xattrSupported is whether setXattr is implemented on this platform.
+const xattrSupported = true
+
+// setXattr sets the extended attribute name of the file at path, as an NTFS
+// alternate data stream.
+func setXattr(path, name, value string) error {
+	return ioutil.WriteFile(path+":"+name, []byte(value), 0600)
+}